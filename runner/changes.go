@@ -0,0 +1,198 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// changedFilesEnv lists the changed files, one per line, for the commands
+	// run by a cycle.
+	changedFilesEnv = "AIR_CHANGED_FILES"
+	// changedFilesJSONEnv is the path of the JSON file with the changed files
+	// and what happened to them.
+	changedFilesJSONEnv = "AIR_CHANGED_FILES_JSON"
+	// maxChangedFilesEnv is the size above which the changed files are only
+	// in the JSON file, as environments are limited in size.
+	maxChangedFilesEnv = 64 << 10
+	// changeSummaryFiles is how many changed files the summary names.
+	changeSummaryFiles = 5
+	// changedFilesJSON is the JSON file in tmp_dir with the changes which
+	// triggered the build.
+	changedFilesJSON = "changed-files.json"
+)
+
+// changedFile is a file changed in a cycle, relative to the root.
+type changedFile struct {
+	Path string `json:"path"`
+	// Op is create, write, remove or rename.
+	Op string `json:"op"`
+}
+
+// changeSet is the deduplicated set of files changed in a cycle, in the
+// order they first changed.
+type changeSet struct {
+	files []changedFile
+	index map[string]int
+}
+
+func opName(op fsnotify.Op) string {
+	switch {
+	case op.Has(fsnotify.Remove):
+		return "remove"
+	case op.Has(fsnotify.Rename):
+		return "rename"
+	case op.Has(fsnotify.Create):
+		return "create"
+	default:
+		return "write"
+	}
+}
+
+// add records that path changed. Writes to a file created in the same cycle
+// keep it a create, and a file created again after being removed or renamed
+// was written; otherwise the latest change wins.
+func (s *changeSet) add(path string, op fsnotify.Op) {
+	name := opName(op)
+	if s.index == nil {
+		s.index = make(map[string]int)
+	}
+	i, ok := s.index[path]
+	if !ok {
+		s.index[path] = len(s.files)
+		s.files = append(s.files, changedFile{Path: path, Op: name})
+		return
+	}
+	prev := s.files[i].Op
+	switch {
+	case prev == "create" && name == "write":
+		return
+	case (prev == "remove" || prev == "rename") && name == "create":
+		name = "write"
+	}
+	s.files[i].Op = name
+}
+
+// merge adds the changes of other, which happened before those of s.
+func (s *changeSet) merge(other *changeSet) *changeSet {
+	if other.len() == 0 {
+		return s
+	}
+	merged := &changeSet{}
+	for _, f := range other.files {
+		merged.addFile(f)
+	}
+	if s != nil {
+		for _, f := range s.files {
+			merged.addFile(f)
+		}
+	}
+	return merged
+}
+
+func (s *changeSet) addFile(f changedFile) {
+	var op fsnotify.Op
+	switch f.Op {
+	case "create":
+		op = fsnotify.Create
+	case "remove":
+		op = fsnotify.Remove
+	case "rename":
+		op = fsnotify.Rename
+	default:
+		op = fsnotify.Write
+	}
+	s.add(f.Path, op)
+}
+
+func (s *changeSet) has(path string) bool {
+	if s == nil {
+		return false
+	}
+	_, ok := s.index[path]
+	return ok
+}
+
+func (s *changeSet) len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.files)
+}
+
+// paths returns the changed paths.
+func (s *changeSet) paths() []string {
+	if s == nil {
+		return nil
+	}
+	paths := make([]string, 0, len(s.files))
+	for _, f := range s.files {
+		paths = append(paths, f.Path)
+	}
+	return paths
+}
+
+// summary describes the changes for the log, naming the first few files.
+func (s *changeSet) summary() string {
+	describe := func(f changedFile) string {
+		switch f.Op {
+		case "create":
+			return f.Path + " (created)"
+		case "remove":
+			return f.Path + " (removed)"
+		case "rename":
+			return f.Path + " (renamed)"
+		default:
+			return f.Path
+		}
+	}
+	if s.len() == 1 {
+		return describe(s.files[0]) + " has changed"
+	}
+	names := make([]string, 0, changeSummaryFiles)
+	for _, f := range s.files[:min(len(s.files), changeSummaryFiles)] {
+		names = append(names, describe(f))
+	}
+	summary := fmt.Sprintf("%d files have changed: %s", len(s.files), strings.Join(names, ", "))
+	if more := len(s.files) - len(names); more > 0 {
+		summary += fmt.Sprintf(" and %d more", more)
+	}
+	return summary
+}
+
+// changesEnv writes the changes to the JSON file name in tmp_dir and returns
+// the environment telling commands about them, or nil without changes.
+func (e *Engine) changesEnv(changes *changeSet, name string) []string {
+	if changes.len() == 0 {
+		return nil
+	}
+	list := strings.Join(changes.paths(), "\n")
+	if len(list) > maxChangedFilesEnv {
+		e.mainDebug("%d changed files are too many for %s, see %s", changes.len(), changedFilesEnv, changedFilesJSONEnv)
+		list = ""
+	}
+	env := []string{changedFilesEnv + "=" + list}
+
+	path := filepath.Join(e.config.tmpPath(), name)
+	if err := writeChanges(path, changes); err != nil {
+		e.mainLog("failed to write the changed files: %s", err.Error())
+		return env
+	}
+	return append(env, changedFilesJSONEnv+"="+path)
+}
+
+func writeChanges(path string, changes *changeSet) error {
+	data, err := json.MarshalIndent(changes.files, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
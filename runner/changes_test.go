@@ -0,0 +1,139 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangeSetAdd(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		ops  []fsnotify.Op
+		want string
+	}{
+		{name: "write", ops: []fsnotify.Op{fsnotify.Write, fsnotify.Write}, want: "write"},
+		{name: "created then written", ops: []fsnotify.Op{fsnotify.Create, fsnotify.Write}, want: "create"},
+		{name: "written then removed", ops: []fsnotify.Op{fsnotify.Write, fsnotify.Remove}, want: "remove"},
+		{name: "atomic save", ops: []fsnotify.Op{fsnotify.Rename, fsnotify.Create, fsnotify.Write}, want: "write"},
+		{name: "recreated", ops: []fsnotify.Op{fsnotify.Remove, fsnotify.Create}, want: "write"},
+		{name: "chmod", ops: []fsnotify.Op{fsnotify.Write | fsnotify.Chmod}, want: "write"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var s changeSet
+			for _, op := range tt.ops {
+				s.add("main.go", op)
+			}
+			assert.Equal(t, []changedFile{{Path: "main.go", Op: tt.want}}, s.files)
+		})
+	}
+}
+
+func TestChangeSetSummary(t *testing.T) {
+	t.Parallel()
+
+	var s changeSet
+	s.add("main.go", fsnotify.Write)
+	assert.Equal(t, "main.go has changed", s.summary())
+
+	s.add("api.go", fsnotify.Create)
+	s.add("old.go", fsnotify.Remove)
+	s.add("main.go", fsnotify.Write)
+	assert.Equal(t, "3 files have changed: main.go, api.go (created), old.go (removed)", s.summary())
+
+	for i := range 5 {
+		s.add(fmt.Sprintf("gen%d.go", i), fsnotify.Write)
+	}
+	assert.Equal(t, "8 files have changed: main.go, api.go (created), old.go (removed), gen0.go, gen1.go and 3 more", s.summary())
+}
+
+func TestChangeSetMerge(t *testing.T) {
+	t.Parallel()
+
+	var prev, next changeSet
+	prev.add("api.go", fsnotify.Create)
+	prev.add("main.go", fsnotify.Write)
+	next.add("api.go", fsnotify.Write)
+	next.add("web.go", fsnotify.Remove)
+
+	assert.Equal(t, []string{"api.go", "main.go", "web.go"}, next.merge(&prev).paths())
+	assert.Equal(t, "create", next.merge(&prev).files[0].Op)
+	assert.Same(t, &next, next.merge(nil))
+	assert.Equal(t, []string{"api.go", "main.go"}, (*changeSet)(nil).merge(&prev).paths())
+}
+
+func TestChangesEnv(t *testing.T) {
+	root := t.TempDir()
+	cfg := defaultConfig()
+	cfg.Root = root
+	cfg.Log.Silent = true
+	e := &Engine{config: &cfg, logger: newLogger(&cfg)}
+
+	assert.Nil(t, e.changesEnv(nil, changedFilesJSON))
+
+	var s changeSet
+	s.add("main.go", fsnotify.Write)
+	s.add(filepath.Join("api", "api.go"), fsnotify.Create)
+	jsonPath := filepath.Join(cfg.tmpPath(), changedFilesJSON)
+	assert.Equal(t, []string{
+		"AIR_CHANGED_FILES=main.go\n" + filepath.Join("api", "api.go"),
+		"AIR_CHANGED_FILES_JSON=" + jsonPath,
+	}, e.changesEnv(&s, changedFilesJSON))
+
+	data, err := os.ReadFile(jsonPath)
+	require.NoError(t, err)
+	var files []changedFile
+	require.NoError(t, json.Unmarshal(data, &files))
+	assert.Equal(t, s.files, files)
+
+	// too many files for the environment are only in the JSON file
+	var large changeSet
+	for i := range 5000 {
+		large.add(filepath.Join("gen", fmt.Sprintf("file%04d.go", i)), fsnotify.Write)
+	}
+	env := e.changesEnv(&large, changedFilesJSON)
+	assert.Equal(t, []string{"AIR_CHANGED_FILES=", "AIR_CHANGED_FILES_JSON=" + jsonPath}, env)
+}
+
+func TestBuildRunExportsChangedFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	root := t.TempDir()
+	out := filepath.Join(root, "changed.txt")
+	cfg := defaultConfig()
+	cfg.Root = root
+	cfg.Log.Silent = true
+	cfg.Build.PreCmd = []string{`printf '%s' "$AIR_CHANGED_FILES" > ` + out}
+	cfg.Build.Cmd = "true"
+	cfg.Build.Bin = "true"
+	require.NoError(t, cfg.preprocess(nil))
+	e, err := NewEngineWithConfig(&cfg, false)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		e.stopBin()
+		_ = e.watcher.Close()
+	})
+
+	var s changeSet
+	s.add("main.go", fsnotify.Write)
+	s.add("api.go", fsnotify.Create)
+	e.buildRun(&s)
+
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"main.go", "api.go"}, strings.Split(string(data), "\n"))
+}
@@ -0,0 +1,164 @@
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// checksumCacheFile is the file in tmp_dir keeping the checksums of
+	// exclude_unchanged between sessions.
+	checksumCacheFile = "checksums.json"
+	// checksumCacheVersion changes with the way checksums are computed, which
+	// invalidates the saved ones.
+	checksumCacheVersion = 2
+)
+
+// savedChecksum is the checksum of a file with its mtime, in nanoseconds,
+// and size when it was hashed.
+type savedChecksum struct {
+	Sum     string `json:"sum"`
+	ModTime int64  `json:"mtime"`
+	Size    int64  `json:"size"`
+}
+
+type checksumCache struct {
+	Version int `json:"version"`
+	// Semantic tells the checksums of .go files are semantic_checksum ones.
+	Semantic bool `json:"semantic"`
+	// Files are keyed by their path relative to the root.
+	Files map[string]savedChecksum `json:"files"`
+}
+
+// lastSession holds the checksums saved by the last session while the files
+// are registered at startup. Files whose mtime and size are unchanged take
+// their saved checksum without being hashed, and the others are collected as
+// changed since.
+type lastSession struct {
+	mu sync.Mutex
+	// files holds the saved checksums of the files not registered yet.
+	files   map[string]savedChecksum
+	changes changeSet
+}
+
+// checksum returns the saved checksum of the file rel if it is unchanged.
+func (s *lastSession) checksum(rel string, info os.FileInfo) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	saved, ok := s.files[rel]
+	if !ok || stampOf(info) != (fileStamp{modTime: saved.ModTime, size: saved.Size}) {
+		return "", false
+	}
+	delete(s.files, rel)
+	return saved.Sum, true
+}
+
+// hashed records the checksum of the file rel, which was hashed as its stat
+// changed since the last session.
+func (s *lastSession) hashed(rel, checksum string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	saved, ok := s.files[rel]
+	switch {
+	case !ok:
+		s.changes.add(rel, fsnotify.Create)
+	case saved.Sum != checksum:
+		s.changes.add(rel, fsnotify.Write)
+	}
+	delete(s.files, rel)
+}
+
+// loadChecksums reads the checksums saved by the last session, which the
+// registration of the watched files then uses.
+func (e *Engine) loadChecksums() {
+	path := filepath.Join(e.config.tmpPath(), checksumCacheFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			e.watcherLog("failed to read the saved checksums: %s", err.Error())
+		}
+		return
+	}
+	var cache checksumCache
+	if err := json.Unmarshal(data, &cache); err != nil || cache.Version != checksumCacheVersion {
+		e.watcherDebug("ignoring the saved checksums, which are invalid or from another version")
+		return
+	}
+	if cache.Semantic != e.config.Build.SemanticChecksum {
+		e.watcherDebug("ignoring the saved checksums, as semantic_checksum changed")
+		return
+	}
+	if cache.Files == nil {
+		cache.Files = make(map[string]savedChecksum)
+	}
+	e.withLock(func() {
+		e.lastSession = &lastSession{files: cache.Files}
+	})
+}
+
+// endLastSession stops using the saved checksums once the watched files are
+// registered, and returns the files changed since the last session.
+func (e *Engine) endLastSession() *changeSet {
+	var s *lastSession
+	e.withLock(func() {
+		s, e.lastSession = e.lastSession, nil
+	})
+	if s == nil {
+		return nil
+	}
+	// saved files left unregistered are gone, or no longer watched
+	for rel := range s.files {
+		if _, err := os.Lstat(filepath.Join(e.config.Root, rel)); errors.Is(err, os.ErrNotExist) {
+			s.changes.add(rel, fsnotify.Remove)
+		}
+	}
+	if s.changes.len() == 0 {
+		return nil
+	}
+	return &s.changes
+}
+
+func (e *Engine) session() *lastSession {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.lastSession
+}
+
+// saveChecksums saves the checksums of the watched files for the next
+// session.
+func (e *Engine) saveChecksums() error {
+	files := e.fileChecksums.stamped()
+	cache := checksumCache{
+		Version:  checksumCacheVersion,
+		Semantic: e.config.Build.SemanticChecksum,
+		Files:    make(map[string]savedChecksum, len(files)),
+	}
+	for name, saved := range files {
+		cache.Files[e.config.rel(name)] = saved
+	}
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(e.config.tmpPath(), checksumCacheFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// a session killed while saving leaves the previous cache
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
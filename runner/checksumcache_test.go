@@ -0,0 +1,101 @@
+package runner
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksumsPersistAcrossSessions(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"main.go":     "package main",
+		"api/api.go":  "package api",
+		"api/gone.go": "package api",
+		"api/same.go": "package api",
+	})
+	session := func() *Engine {
+		t.Helper()
+		cfg := defaultConfig()
+		cfg.Root = root
+		cfg.Log.Silent = true
+		cfg.Build.ExcludeUnchanged = true
+		require.NoError(t, cfg.preprocess(nil))
+		e, err := NewEngineWithConfig(&cfg, false)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = e.watcher.Close() })
+		require.NoError(t, e.watchConfiguredDirs())
+		return e
+	}
+
+	first := session()
+	assert.Nil(t, first.startChanges, "nothing to compare the first session with")
+	require.NoError(t, first.saveChecksums())
+
+	// a file whose stat didn't change isn't hashed again, so a wrong checksum
+	// saved for it is taken as is
+	path := filepath.Join(first.config.tmpPath(), checksumCacheFile)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var cache checksumCache
+	require.NoError(t, json.Unmarshal(data, &cache))
+	same := filepath.Join("api", "same.go")
+	saved := cache.Files[same]
+	saved.Sum = "saved"
+	cache.Files[same] = saved
+	data, err = json.Marshal(cache)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	// changes made while air wasn't running
+	later := time.Now().Add(time.Minute)
+	writeFiles(t, root, map[string]string{
+		"api/api.go": "package api // changed",
+		"web/web.go": "package web",
+	})
+	require.NoError(t, os.Chtimes(filepath.Join(root, "api", "api.go"), later, later))
+	require.NoError(t, os.Chtimes(filepath.Join(root, "main.go"), later, later))
+	require.NoError(t, os.Remove(filepath.Join(root, "api", "gone.go")))
+
+	second := session()
+	checksum, ok := second.fileChecksums.get(filepath.Join(root, "api", "same.go"))
+	require.True(t, ok)
+	assert.Equal(t, "saved", checksum)
+	// main.go was touched, but its contents are the same
+	require.NotNil(t, second.startChanges)
+	assert.ElementsMatch(t, []changedFile{
+		{Path: filepath.Join("api", "api.go"), Op: "write"},
+		{Path: filepath.Join("web", "web.go"), Op: "create"},
+		{Path: filepath.Join("api", "gone.go"), Op: "remove"},
+	}, second.startChanges.files)
+	assert.Nil(t, second.session(), "saved checksums are only used at startup")
+}
+
+func TestChecksumsOfAnotherVersionAreIgnored(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"main.go": "package main"})
+	cfg := defaultConfig()
+	cfg.Root = root
+	cfg.Log.Silent = true
+	e := &Engine{config: &cfg, logger: newLogger(&cfg)}
+
+	path := filepath.Join(cfg.tmpPath(), checksumCacheFile)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	data, err := json.Marshal(checksumCache{
+		Version: checksumCacheVersion + 1,
+		Files:   map[string]savedChecksum{"main.go": {Sum: "saved"}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+	e.loadChecksums()
+	assert.Nil(t, e.session())
+
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o644))
+	e.loadChecksums()
+	assert.Nil(t, e.session())
+}
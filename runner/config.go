@@ -4,6 +4,9 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	pathpkg "path"
@@ -11,6 +14,7 @@ import (
 	"reflect"
 	"regexp"
 	"runtime"
+	"slices"
 	"strings"
 	"time"
 
@@ -24,7 +28,12 @@ const (
 	dftTOML = ".air.toml"
 	airWd   = "air_wd"
 
-	defaultProxyAppStartTimeout = 5000
+	defaultProxyAppStartTimeout  = 5000
+	defaultProxyInspectEntries   = 100
+	defaultProxyInspectBodyLimit = 64 << 10
+	// staticRuleDelay debounces the files a frontend build writes into a
+	// static dir, in milliseconds.
+	staticRuleDelay = 100
 
 	schemaHeader = "#:schema https://json.schemastore.org/any.json"
 )
@@ -106,11 +115,17 @@ type cfgBuild struct {
 	ExcludeFile            []string           `toml:"exclude_file" usage:"Exclude files"`
 	IncludeFile            []string           `toml:"include_file" usage:"Watch these files"`
 	ExcludeRegex           []string           `toml:"exclude_regex" usage:"Exclude specific regular expressions"`
+	Include                []string           `toml:"include" usage:"Watch files matching these globs, e.g. internal/**/*.go; ! negates, the last match wins"`
+	Exclude                []string           `toml:"exclude" usage:"Ignore files and directories matching these globs, e.g. **/*_gen.go; ! negates, the last match wins"`
 	ExcludeUnchanged       bool               `toml:"exclude_unchanged" usage:"Exclude unchanged files"`
+	SemanticChecksum       bool               `toml:"semantic_checksum" usage:"With exclude_unchanged, also exclude .go files whose changes only touch comments, formatting or the order of imports"`
+	UseGitignore           bool               `toml:"use_gitignore" usage:"Skip files and directories ignored by .gitignore files and .git/info/exclude"`
 	IgnoreDangerousRootDir bool               `toml:"ignore_dangerous_root_dir" usage:"Ignore dangerous root directory that could cause excessive file watching"`
 	FollowSymlink          bool               `toml:"follow_symlink" usage:"Follow symlink for directories"`
 	Poll                   bool               `toml:"poll" usage:"Poll files for changes instead of using fsnotify"`
 	PollInterval           int                `toml:"poll_interval" usage:"Poll interval (defaults to the minimum interval of 500ms)"`
+	PollDirs               []string           `toml:"poll_dirs" usage:"Poll these directories, e.g. network or container mounts, and use fsnotify for the rest"`
+	PollDirsInterval       int                `toml:"poll_dirs_interval" usage:"Poll interval of poll_dirs (defaults to the minimum interval of 500ms)"`
 	Delay                  int                `toml:"delay" usage:"It's not necessary to trigger build each time file changes if it's too frequent"`
 	StopOnError            bool               `toml:"stop_on_error" usage:"Stop running old binary when build errors occur"`
 	SendInterrupt          bool               `toml:"send_interrupt" usage:"Send Interrupt signal before killing process (windows does not support this feature)"`
@@ -122,8 +137,11 @@ type cfgBuild struct {
 	Darwin                 *cfgBuildOverrides `toml:"darwin,omitempty"`
 	Linux                  *cfgBuildOverrides `toml:"linux,omitempty"`
 	regexCompiled          []*regexp.Regexp
+	include                globList
+	exclude                globList
 	includeDirAbs          []string
 	extraIncludeDirs       []string
+	pollDirsAbs            []string
 }
 
 func (c *cfgBuild) RegexCompiled() ([]*regexp.Regexp, error) {
@@ -139,8 +157,13 @@ type cfgRule struct {
 	IncludeExt    []string `toml:"include_ext" usage:"Match these filename extensions"`
 	IncludeFile   []string `toml:"include_file" usage:"Match these files"`
 	ExcludeRegex  []string `toml:"exclude_regex" usage:"Exclude specific regular expressions"`
+	Include       []string `toml:"include" usage:"Match files matching these globs; ! negates, the last match wins"`
+	Exclude       []string `toml:"exclude" usage:"Never match files matching these globs; ! negates, the last match wins"`
 	Delay         int      `toml:"delay" usage:"Debounce delay in milliseconds before running cmd"`
+	HotSwap       bool     `toml:"hot_swap" usage:"Swap changed stylesheets and images in the browser instead of reloading it (requires proxy)"`
 	regexCompiled []*regexp.Regexp
+	include       globList
+	exclude       globList
 	includeDirAbs []string
 }
 
@@ -157,11 +180,11 @@ func (c *cfgBuild) normalizeRules(root string) error {
 		if r.Name == "" {
 			r.Name = fmt.Sprintf("rule-%d", i)
 		}
-		if r.Cmd == "" {
-			return fmt.Errorf("build.rules[%d] (%s): cmd is required", i, r.Name)
+		if r.Cmd == "" && !r.HotSwap {
+			return fmt.Errorf("build.rules[%d] (%s): cmd is required unless hot_swap is set", i, r.Name)
 		}
-		if len(r.IncludeDir) == 0 && len(r.IncludeExt) == 0 && len(r.IncludeFile) == 0 {
-			return fmt.Errorf("build.rules[%d] (%s): at least one of include_dir, include_ext or include_file is required", i, r.Name)
+		if len(r.IncludeDir) == 0 && len(r.IncludeExt) == 0 && len(r.IncludeFile) == 0 && len(r.Include) == 0 {
+			return fmt.Errorf("build.rules[%d] (%s): at least one of include_dir, include_ext, include_file or include is required", i, r.Name)
 		}
 		r.includeDirAbs = r.includeDirAbs[:0]
 		for _, dir := range r.IncludeDir {
@@ -183,6 +206,13 @@ func (c *cfgBuild) normalizeRules(root string) error {
 			}
 			r.regexCompiled = append(r.regexCompiled, re)
 		}
+		var err error
+		if r.include, err = compileGlobs(r.Include); err != nil {
+			return fmt.Errorf("build.rules[%d] (%s): include: %w", i, r.Name, err)
+		}
+		if r.exclude, err = compileGlobs(r.Exclude); err != nil {
+			return fmt.Errorf("build.rules[%d] (%s): exclude: %w", i, r.Name, err)
+		}
 	}
 	return nil
 }
@@ -212,6 +242,33 @@ func (c *cfgBuild) normalizeIncludeDirs(root string) {
 	}
 }
 
+func (c *cfgBuild) normalizePollDirs(root string) {
+	c.pollDirsAbs = c.pollDirsAbs[:0]
+	for _, dir := range c.PollDirs {
+		dir = cleanPath(dir)
+		if dir == "" {
+			continue
+		}
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(root, dir)
+		}
+		c.pollDirsAbs = append(c.pollDirsAbs, filepath.Clean(dir))
+	}
+}
+
+// isPolled reports whether path is polled rather than watched with fsnotify.
+func (c *cfgBuild) isPolled(path string) bool {
+	if c.Poll {
+		return true
+	}
+	for _, dir := range c.pollDirsAbs {
+		if isSubPath(dir, path) {
+			return true
+		}
+	}
+	return false
+}
+
 type cfgLog struct {
 	AddTime  bool `toml:"time" usage:"Show log time"`
 	MainOnly bool `toml:"main_only" usage:"Only show main log (silences watcher, build, runner)"`
@@ -223,6 +280,7 @@ type cfgColor struct {
 	Watcher string `toml:"watcher" usage:"Customize watcher part's color"`
 	Build   string `toml:"build" usage:"Customize build part's color"`
 	Runner  string `toml:"runner" usage:"Customize runner part's color"`
+	Proxy   string `toml:"proxy" usage:"Customize proxy part's color"`
 	Mode    string `toml:"mode" usage:"Colorized output mode, one of always, auto, or never. Defaults to auto"`
 	App     string `toml:"app"`
 }
@@ -238,10 +296,237 @@ type cfgScreen struct {
 }
 
 type cfgProxy struct {
-	Enabled         bool `toml:"enabled" usage:"Enable live-reloading on the browser"`
-	ProxyPort       int  `toml:"proxy_port" usage:"Port for proxy server"`
-	AppPort         int  `toml:"app_port" usage:"Port for your app"`
-	AppStartTimeout int  `toml:"app_start_timeout" usage:"Timeout for waiting for app to start in milliseconds (default 5000)"`
+	Enabled           bool             `toml:"enabled" usage:"Enable live-reloading on the browser"`
+	ProxyPort         int              `toml:"proxy_port" usage:"Port for proxy server"`
+	AppPort           int              `toml:"app_port" usage:"Port for your app"`
+	Listen            string           `toml:"listen" usage:"Address the proxy listens on: host:port or unix:/path/to.sock (default localhost:<proxy_port>)"`
+	Upstream          string           `toml:"upstream" usage:"Address of your app: scheme://host:port or unix:/path/to.sock (default http://localhost:<app_port>)"`
+	UpstreamInsecure  bool             `toml:"upstream_insecure" usage:"Skip TLS certificate verification for an https upstream"`
+	AppStartTimeout   int              `toml:"app_start_timeout" usage:"Timeout for waiting for app to start in milliseconds (default 5000)"`
+	AccessLog         bool             `toml:"access_log" usage:"Log every proxied request in the air terminal"`
+	AccessLogStatic   bool             `toml:"access_log_static" usage:"Include static assets (css, js, images, fonts) in the access log"`
+	AccessLogInternal bool             `toml:"access_log_internal" usage:"Include air's own /__air_internal requests in the access log"`
+	Inspect           bool             `toml:"inspect" usage:"Record recent requests, viewable at /__air_internal/inspect and exportable as HAR"`
+	InspectEntries    int              `toml:"inspect_entries" usage:"Number of requests kept by the inspector (default 100)"`
+	InspectBodyLimit  int              `toml:"inspect_body_limit" usage:"Bytes of each request and response body kept by the inspector (default 65536)"`
+	ConsoleErrors     bool             `toml:"console_errors" usage:"Print uncaught exceptions and console.error calls from the browser in the air terminal"`
+	PreserveState     *bool            `toml:"preserve_state" usage:"Restore scroll position and form state after a live reload (default true)"`
+	OpenBrowser       bool             `toml:"open_browser" usage:"Open the proxy in the browser once the app is first ready after a successful build"`
+	OpenPath          string           `toml:"open_path" usage:"Path of the page opened by open_browser (default /)"`
+	OpenCommand       string           `toml:"open_command" usage:"Command opening the page instead of the platform default, e.g. firefox"`
+	Routes            []cfgProxyRoute  `toml:"routes"`
+	Faults            []cfgProxyFault  `toml:"faults"`
+	Static            []cfgProxyStatic `toml:"static"`
+}
+
+// preserveState reports whether the browser keeps its scroll position and
+// form state across live reloads. It is on unless disabled explicitly.
+func (c *cfgProxy) preserveState() bool {
+	return c.PreserveState == nil || *c.PreserveState
+}
+
+// unixSocketPath returns the socket path of addr if it names a Unix socket,
+// either as unix:/path/to.sock or as an absolute path.
+func unixSocketPath(addr string) (string, bool) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return path, true
+	}
+	return addr, strings.HasPrefix(addr, "/")
+}
+
+// listenAddr returns the network and address the proxy listens on. It only
+// accepts connections from this machine unless listen says otherwise.
+func (c *cfgProxy) listenAddr() (network, addr string) {
+	if path, ok := unixSocketPath(c.Listen); ok {
+		return "unix", path
+	}
+	if c.Listen != "" {
+		return "tcp", c.Listen
+	}
+	return "tcp", fmt.Sprintf("localhost:%d", c.ProxyPort)
+}
+
+// appUpstream returns the URL the app is reached at, and the Unix socket to
+// dial for it, if any.
+func (c *cfgProxy) appUpstream() (u *url.URL, socket string) {
+	if path, ok := unixSocketPath(c.Upstream); ok {
+		return &url.URL{Scheme: "http", Host: "localhost"}, path
+	}
+	if c.Upstream != "" {
+		if u, err := url.Parse(c.Upstream); err == nil {
+			return &url.URL{Scheme: u.Scheme, Host: u.Host}, ""
+		}
+	}
+	return &url.URL{Scheme: "http", Host: fmt.Sprintf("localhost:%d", c.AppPort)}, ""
+}
+
+func (c *cfgProxy) normalizeAddrs() error {
+	c.OpenPath = strings.TrimSpace(c.OpenPath)
+	if c.OpenPath != "" && !strings.HasPrefix(c.OpenPath, "/") {
+		return fmt.Errorf("proxy.open_path %q must start with /", c.OpenPath)
+	}
+
+	c.Listen = strings.TrimSpace(c.Listen)
+	if path, ok := unixSocketPath(c.Listen); ok {
+		if path == "" {
+			return errors.New("proxy.listen: unix socket path is empty")
+		}
+	} else if c.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+			return fmt.Errorf("proxy.listen %q must be host:port or unix:/path/to.sock: %w", c.Listen, err)
+		}
+	}
+
+	c.Upstream = strings.TrimSpace(c.Upstream)
+	if path, ok := unixSocketPath(c.Upstream); ok {
+		if path == "" {
+			return errors.New("proxy.upstream: unix socket path is empty")
+		}
+		return nil
+	}
+	if c.Upstream == "" {
+		return nil
+	}
+	u, err := url.Parse(c.Upstream)
+	if err != nil {
+		return fmt.Errorf("proxy.upstream %q: %w", c.Upstream, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("proxy.upstream %q must be http://host:port, https://host:port or unix:/path/to.sock", c.Upstream)
+	}
+	if u.Path != "" && u.Path != "/" {
+		return fmt.Errorf("proxy.upstream %q must not have a path", c.Upstream)
+	}
+	return nil
+}
+
+// cfgProxyRoute forwards requests for a host and/or path prefix to an
+// upstream other than the app, e.g. a frontend dev server or another app.
+type cfgProxyRoute struct {
+	Host         string `toml:"host" usage:"Forward requests for this host, e.g. admin.app.localhost or *.app.localhost"`
+	Path         string `toml:"path" usage:"Forward requests under this path prefix"`
+	StripPrefix  bool   `toml:"strip_prefix" usage:"Remove the path prefix before forwarding"`
+	Upstream     string `toml:"upstream" usage:"host:port to forward to; empty forwards to app_port"`
+	Inject       bool   `toml:"inject" usage:"Inject the live reload script into HTML responses"`
+	PreserveHost bool   `toml:"preserve_host" usage:"Send the browser's Host header to the upstream"`
+	HostHeader   string `toml:"host_header" usage:"Host header to send to the upstream"`
+}
+
+func (c *cfgProxy) normalizeRoutes() error {
+	for i := range c.Routes {
+		r := &c.Routes[i]
+		r.Host = strings.ToLower(strings.TrimSpace(r.Host))
+		r.Path = strings.TrimSpace(r.Path)
+		if r.Path == "" && r.Host == "" {
+			return fmt.Errorf("proxy.routes[%d]: host or path is required", i)
+		}
+		if r.Path == "" {
+			r.Path = "/"
+		}
+		if !strings.HasPrefix(r.Path, "/") {
+			return fmt.Errorf("proxy.routes[%d]: path %q must start with /", i, r.Path)
+		}
+		if strings.Contains(strings.TrimPrefix(r.Host, "*."), "*") {
+			return fmt.Errorf("proxy.routes[%d]: host %q may only use a leading *. wildcard", i, r.Host)
+		}
+		if r.PreserveHost && r.HostHeader != "" {
+			return fmt.Errorf("proxy.routes[%d]: preserve_host and host_header are mutually exclusive", i)
+		}
+		r.Upstream = strings.TrimSpace(r.Upstream)
+		if r.Upstream == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(r.Upstream); err != nil {
+			return fmt.Errorf("proxy.routes[%d]: upstream %q must be host:port: %w", i, r.Upstream, err)
+		}
+	}
+	return nil
+}
+
+// cfgProxyFault degrades the responses for requests under a path prefix, to
+// exercise loading states and timeouts. Faults can also be changed at runtime
+// through /__air_internal/faults, which takes the same fields as JSON.
+type cfgProxyFault struct {
+	Path        string  `toml:"path" json:"path" usage:"Apply the fault to requests under this path prefix"`
+	Latency     int     `toml:"latency" json:"latency,omitempty" usage:"Delay in milliseconds before forwarding the request"`
+	Bandwidth   int     `toml:"bandwidth" json:"bandwidth,omitempty" usage:"Throttle response bodies to this many bytes per second"`
+	ErrorRate   float64 `toml:"error_rate" json:"error_rate,omitempty" usage:"Fraction of requests, from 0 to 1, answered with error_status"`
+	ErrorStatus int     `toml:"error_status" json:"error_status,omitempty" usage:"Status code of injected errors (default 503)"`
+	ResetRate   float64 `toml:"reset_rate" json:"reset_rate,omitempty" usage:"Fraction of requests, from 0 to 1, whose connection is reset"`
+}
+
+func (f *cfgProxyFault) normalize() error {
+	f.Path = strings.TrimSpace(f.Path)
+	if f.Path == "" {
+		f.Path = "/"
+	}
+	if !strings.HasPrefix(f.Path, "/") {
+		return fmt.Errorf("path %q must start with /", f.Path)
+	}
+	if f.Latency < 0 || f.Bandwidth < 0 {
+		return errors.New("latency and bandwidth must not be negative")
+	}
+	if f.ErrorRate < 0 || f.ErrorRate > 1 || f.ResetRate < 0 || f.ResetRate > 1 {
+		return errors.New("error_rate and reset_rate must be between 0 and 1")
+	}
+	if f.ErrorStatus == 0 {
+		f.ErrorStatus = http.StatusServiceUnavailable
+	}
+	if f.ErrorStatus < 400 || f.ErrorStatus > 599 {
+		return fmt.Errorf("error_status %d must be a 4xx or 5xx status", f.ErrorStatus)
+	}
+	return nil
+}
+
+func (c *cfgProxy) normalizeFaults() error {
+	for i := range c.Faults {
+		if err := c.Faults[i].normalize(); err != nil {
+			return fmt.Errorf("proxy.faults[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// cfgProxyStatic serves the files under a directory at a path prefix straight
+// from disk, bypassing the app. Changes to them reload the browser, or swap
+// stylesheets and images in place, without a rebuild.
+type cfgProxyStatic struct {
+	Path string `toml:"path" usage:"Serve the files under this path prefix"`
+	Dir  string `toml:"dir" usage:"Directory the files are served from, relative to the root"`
+}
+
+func (c *cfgProxy) normalizeStatic(root string) error {
+	for i := range c.Static {
+		s := &c.Static[i]
+		s.Path = strings.TrimSpace(s.Path)
+		if !strings.HasPrefix(s.Path, "/") {
+			return fmt.Errorf("proxy.static[%d]: path %q must start with /", i, s.Path)
+		}
+		dir := cleanPath(s.Dir)
+		if dir == "" {
+			return fmt.Errorf("proxy.static[%d]: dir is required", i)
+		}
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(root, dir)
+		}
+		s.Dir = filepath.Clean(dir)
+	}
+	return nil
+}
+
+// staticRules returns a hot swap rule for each static dir, so that their
+// changes reach the browser and never trigger a rebuild.
+func (c *cfgProxy) staticRules() []cfgRule {
+	rules := make([]cfgRule, 0, len(c.Static))
+	for _, s := range c.Static {
+		rules = append(rules, cfgRule{
+			Name:       "static " + s.Path,
+			IncludeDir: []string{s.Dir},
+			Delay:      staticRuleDelay,
+			HotSwap:    true,
+		})
+	}
+	return rules
 }
 
 type sliceTransformer struct{}
@@ -411,6 +696,9 @@ func defaultConfigBase() Config {
 		IncludeFile:  []string{},
 		ExcludeDir:   []string{"assets", "tmp", "vendor", "testdata"},
 		ExcludeRegex: []string{"_test.go"},
+		Include:      []string{},
+		Exclude:      []string{},
+		PollDirs:     []string{},
 		Delay:        1000,
 		Rerun:        false,
 		RerunDelay:   500,
@@ -425,6 +713,7 @@ func defaultConfigBase() Config {
 		Watcher: "cyan",
 		Build:   "yellow",
 		Runner:  "green",
+		Proxy:   "blue",
 	}
 	misc := cfgMisc{
 		CleanOnExit: false,
@@ -671,9 +960,30 @@ func (c *Config) preprocess(args map[string]TomlInfo) error {
 
 	adaptToVariousPlatforms(c)
 	c.Build.normalizeIncludeDirs(c.Root)
+	c.Build.normalizePollDirs(c.Root)
+	if c.Proxy.Enabled {
+		if err = c.Proxy.normalizeStatic(c.Root); err != nil {
+			return err
+		}
+		for _, rule := range c.Proxy.staticRules() {
+			// preprocess may run again on the same config
+			if !slices.ContainsFunc(c.Build.Rules, func(r cfgRule) bool { return r.Name == rule.Name }) {
+				c.Build.Rules = append(c.Build.Rules, rule)
+			}
+		}
+	}
 	if err = c.Build.normalizeRules(c.Root); err != nil {
 		return err
 	}
+	if err = c.Proxy.normalizeAddrs(); err != nil {
+		return err
+	}
+	if err = c.Proxy.normalizeRoutes(); err != nil {
+		return err
+	}
+	if err = c.Proxy.normalizeFaults(); err != nil {
+		return err
+	}
 
 	// Join runtime arguments with the configuration arguments
 	runtimeArgs := flag.Args()
@@ -691,6 +1001,12 @@ func (c *Config) preprocess(args map[string]TomlInfo) error {
 		}
 		c.Build.regexCompiled = regexCompiled
 	}
+	if c.Build.include, err = compileGlobs(c.Build.Include); err != nil {
+		return fmt.Errorf("build.include: %w", err)
+	}
+	if c.Build.exclude, err = compileGlobs(c.Build.Exclude); err != nil {
+		return fmt.Errorf("build.exclude: %w", err)
+	}
 
 	c.Build.ExcludeDir = ed
 
@@ -809,6 +1125,7 @@ func (c *Config) colorInfo() map[string]string {
 		"build":   c.Color.Build,
 		"runner":  c.Color.Runner,
 		"watcher": c.Color.Watcher,
+		"proxy":   c.Color.Proxy,
 	}
 }
 
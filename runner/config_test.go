@@ -477,6 +477,32 @@ func TestWithArgsSetsStartupBanner(t *testing.T) {
 	})
 }
 
+func TestWithArgsSetsPreserveState(t *testing.T) {
+	t.Parallel()
+
+	cfg := defaultConfig()
+	if !cfg.Proxy.preserveState() {
+		t.Fatal("preserve_state should default to true")
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	args := ParseConfigFlag(fs)
+	info, ok := args["proxy.preserve_state"]
+	if !ok {
+		t.Fatal("proxy.preserve_state flag mapping missing")
+	}
+	*info.Value = "false"
+
+	cfg.withArgs(args)
+
+	if cfg.Proxy.PreserveState == nil {
+		t.Fatal("preserve_state should be set")
+	}
+	if cfg.Proxy.preserveState() {
+		t.Fatal("preserve_state should be disabled")
+	}
+}
+
 func TestInitConfigForDisplayStartupBanner(t *testing.T) {
 	t.Parallel()
 
@@ -1219,3 +1245,174 @@ func TestColorModeWithFullBin(t *testing.T) {
 		t.Fatal("color.NoColor should be true when mode=never and full_bin is set")
 	}
 }
+
+func TestNormalizeProxyRoutes(t *testing.T) {
+	t.Parallel()
+
+	valid := cfgProxy{Routes: []cfgProxyRoute{
+		{Path: "/api", Upstream: " localhost:5173 "},
+		{Path: "/app"},
+		{Host: "*.App.localhost"},
+	}}
+	if err := valid.normalizeRoutes(); err != nil {
+		t.Fatalf("normalizeRoutes() error = %v", err)
+	}
+	if got := valid.Routes[0].Upstream; got != "localhost:5173" {
+		t.Fatalf("upstream = %q, want trimmed", got)
+	}
+	if got := valid.Routes[2]; got.Host != "*.app.localhost" || got.Path != "/" {
+		t.Fatalf("host route = %+v, want lowercase host and / path", got)
+	}
+
+	for _, route := range []cfgProxyRoute{
+		{Upstream: "localhost:5173"},
+		{Path: "api", Upstream: "localhost:5173"},
+		{Path: "/api", Upstream: "localhost"},
+		{Host: "app.*.localhost"},
+		{Host: "app.localhost", PreserveHost: true, HostHeader: "x"},
+	} {
+		cfg := cfgProxy{Routes: []cfgProxyRoute{route}}
+		if err := cfg.normalizeRoutes(); err == nil {
+			t.Errorf("normalizeRoutes(%+v) expected error", route)
+		}
+	}
+}
+
+func TestNormalizeProxyFaults(t *testing.T) {
+	t.Parallel()
+
+	valid := cfgProxy{Faults: []cfgProxyFault{{Latency: 500, ErrorRate: 0.5}}}
+	if err := valid.normalizeFaults(); err != nil {
+		t.Fatalf("normalizeFaults() error = %v", err)
+	}
+	if got := valid.Faults[0]; got.Path != "/" || got.ErrorStatus != 503 {
+		t.Fatalf("fault = %+v, want / path and 503 status", got)
+	}
+
+	for _, fault := range []cfgProxyFault{
+		{Path: "api"},
+		{Latency: -1},
+		{ErrorRate: 1.5},
+		{ResetRate: -0.1},
+		{ErrorRate: 0.1, ErrorStatus: 200},
+	} {
+		cfg := cfgProxy{Faults: []cfgProxyFault{fault}}
+		if err := cfg.normalizeFaults(); err == nil {
+			t.Errorf("normalizeFaults(%+v) expected error", fault)
+		}
+	}
+}
+
+func TestProxyStaticRules(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	cfg := defaultConfig()
+	cfg.Root = root
+	cfg.Proxy.Enabled = true
+	cfg.Proxy.Static = []cfgProxyStatic{{Path: "/assets", Dir: "web/dist"}}
+	// preprocess can run more than once on a config
+	for range 2 {
+		if err := cfg.preprocess(nil); err != nil {
+			t.Fatalf("preprocess error %v", err)
+		}
+	}
+
+	dir := filepath.Join(cfg.Root, "web", "dist")
+	if cfg.Proxy.Static[0].Dir != dir {
+		t.Fatalf("static dir = %s, want %s", cfg.Proxy.Static[0].Dir, dir)
+	}
+	if len(cfg.Build.Rules) != 1 {
+		t.Fatalf("got %d rules, want 1", len(cfg.Build.Rules))
+	}
+	rule := cfg.Build.Rules[0]
+	if rule.Name != "static /assets" || !rule.HotSwap || !reflect.DeepEqual(rule.includeDirAbs, []string{dir}) {
+		t.Fatalf("static rule = %+v", rule)
+	}
+
+	for _, static := range []cfgProxyStatic{
+		{Path: "assets", Dir: "web/dist"},
+		{Path: "/assets"},
+	} {
+		cfg := cfgProxy{Static: []cfgProxyStatic{static}}
+		if err := cfg.normalizeStatic(root); err == nil {
+			t.Errorf("normalizeStatic(%+v) expected error", static)
+		}
+	}
+}
+
+func TestNormalizeProxyAddrs(t *testing.T) {
+	t.Parallel()
+
+	defaults := cfgProxy{ProxyPort: 8090, AppPort: 8080}
+	if err := defaults.normalizeAddrs(); err != nil {
+		t.Fatalf("normalizeAddrs() error = %v", err)
+	}
+	if network, addr := defaults.listenAddr(); network != "tcp" || addr != "localhost:8090" {
+		t.Fatalf("listenAddr() = %s %s, want tcp localhost:8090", network, addr)
+	}
+	if u, socket := defaults.appUpstream(); u.String() != "http://localhost:8080" || socket != "" {
+		t.Fatalf("appUpstream() = %s %q, want http://localhost:8080", u, socket)
+	}
+
+	sockets := cfgProxy{Listen: "unix:/tmp/air.sock", Upstream: "/tmp/app.sock"}
+	if err := sockets.normalizeAddrs(); err != nil {
+		t.Fatalf("normalizeAddrs() error = %v", err)
+	}
+	if network, addr := sockets.listenAddr(); network != "unix" || addr != "/tmp/air.sock" {
+		t.Fatalf("listenAddr() = %s %s, want unix /tmp/air.sock", network, addr)
+	}
+	if _, socket := sockets.appUpstream(); socket != "/tmp/app.sock" {
+		t.Fatalf("appUpstream() socket = %q, want /tmp/app.sock", socket)
+	}
+
+	https := cfgProxy{Listen: "[::1]:8090", Upstream: " https://app.docker.internal:8443 "}
+	if err := https.normalizeAddrs(); err != nil {
+		t.Fatalf("normalizeAddrs() error = %v", err)
+	}
+	if u, _ := https.appUpstream(); u.String() != "https://app.docker.internal:8443" {
+		t.Fatalf("appUpstream() = %s, want https://app.docker.internal:8443", u)
+	}
+
+	for _, cfg := range []cfgProxy{
+		{Listen: "8090"},
+		{Listen: "unix:"},
+		{Upstream: "localhost:8080"},
+		{Upstream: "ftp://localhost:8080"},
+		{Upstream: "http://localhost:8080/app"},
+		{OpenPath: "dashboard"},
+	} {
+		if err := cfg.normalizeAddrs(); err == nil {
+			t.Errorf("normalizeAddrs(%+v) expected error", cfg)
+		}
+	}
+}
+
+func TestPollDirs(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	mount := filepath.Join(t.TempDir(), "mnt")
+	cfg := defaultConfig()
+	cfg.Root = root
+	cfg.Build.PollDirs = []string{"./shared/", mount, " "}
+	if err := cfg.preprocess(nil); err != nil {
+		t.Fatalf("preprocess error %v", err)
+	}
+
+	want := []string{filepath.Join(root, "shared"), mount}
+	if !reflect.DeepEqual(cfg.Build.pollDirsAbs, want) {
+		t.Fatalf("pollDirsAbs = %v, want %v", cfg.Build.pollDirsAbs, want)
+	}
+	for path, polled := range map[string]bool{
+		filepath.Join(root, "shared"):            true,
+		filepath.Join(root, "shared", "a", "b"):  true,
+		filepath.Join(root, "sharedx"):           false,
+		filepath.Join(root, "cmd"):               false,
+		filepath.Join(mount, "data", "seed.sql"): true,
+	} {
+		if got := cfg.Build.isPolled(path); got != polled {
+			t.Errorf("isPolled(%s) = %v, want %v", path, got, polled)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package runner
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// crashIdle is how long a stack trace may pause before it is taken as
+	// complete. Panics recovered by the app don't end with the process.
+	crashIdle = 200 * time.Millisecond
+	// crashMaxLines caps the lines kept of a single trace.
+	crashMaxLines = 1000
+	// goroutineDumpMessage is the message of a trace that starts at a
+	// goroutine header, e.g. one net/http printed for a recovered panic. The
+	// line before it may be anything the app printed.
+	goroutineDumpMessage = "goroutine dump"
+)
+
+var (
+	goroutineHeaderRe = regexp.MustCompile(`^goroutine \d+ \[[^\]]*\]:$`)
+	frameLocationRe   = regexp.MustCompile(`^\t(.+):(\d+)(?: \+0x[0-9a-f]+)?$`)
+	// logLineRe matches the default prefix of the standard log package
+	logLineRe = regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}(\.\d+)? `)
+)
+
+// crashDetector scans the app's stderr, line by line, for Go panics, fatal
+// runtime errors and log.Fatal calls, and reports them to the browser.
+type crashDetector struct {
+	root   string
+	report func(RuntimeErrorMsg)
+
+	mu      sync.Mutex
+	partial string
+	// last is the last line outside of a trace, the message of log.Fatal if
+	// the app exits next.
+	last    string
+	kind    string
+	message string
+	trace   []string
+	timer   *time.Timer
+	// idle is the trace last reported as its output paused, while the app
+	// may still be exiting. Any other line clears it.
+	idle *RuntimeErrorMsg
+}
+
+func newCrashDetector(root string, report func(RuntimeErrorMsg)) *crashDetector {
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	return &crashDetector{root: root, report: report}
+}
+
+func (d *crashDetector) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	lines := strings.Split(d.partial+string(p), "\n")
+	d.partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		d.line(strings.TrimSuffix(line, "\r"))
+	}
+	return len(p), nil
+}
+
+func (d *crashDetector) line(line string) {
+	switch {
+	case d.trace == nil && strings.HasPrefix(line, "panic: "):
+		d.start("panic", strings.TrimPrefix(line, "panic: "), line)
+	case d.trace == nil && strings.HasPrefix(line, "fatal error: "):
+		d.start("fatal error", strings.TrimPrefix(line, "fatal error: "), line)
+	case d.trace == nil && goroutineHeaderRe.MatchString(line):
+		d.start("panic", goroutineDumpMessage, line)
+	case d.trace != nil && isTraceLine(line):
+		if len(d.trace) < crashMaxLines {
+			d.trace = append(d.trace, line)
+		}
+		d.timer.Reset(crashIdle)
+	default:
+		if d.trace != nil {
+			d.flush(false)
+		}
+		d.last = line
+		d.idle = nil
+	}
+}
+
+func (d *crashDetector) start(kind, message, line string) {
+	d.kind, d.message, d.trace = kind, message, []string{line}
+	d.idle = nil
+	if d.timer == nil {
+		d.timer = time.AfterFunc(crashIdle, func() {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			if d.trace != nil {
+				msg := d.flush(false)
+				d.idle = &msg
+			}
+		})
+	} else {
+		d.timer.Reset(crashIdle)
+	}
+}
+
+func (d *crashDetector) flush(exited bool) RuntimeErrorMsg {
+	d.timer.Stop()
+	msg := parseTrace(d.root, d.kind, d.message, d.trace)
+	msg.Exited = exited
+	d.trace = nil
+	d.report(msg)
+	return msg
+}
+
+// exited reports the trace being collected, if any, once the app exited
+// with code. A trace already reported as its output paused is reported
+// again as fatal if the app failed right after it. Without one, an exit code
+// of 1 after a line from the log package is taken as log.Fatal.
+func (d *crashDetector) exited(code int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.partial != "" {
+		d.line(d.partial)
+		d.partial = ""
+	}
+	switch {
+	case d.trace != nil:
+		d.flush(true)
+	case d.idle != nil && code != 0 && code != -1:
+		msg := *d.idle
+		msg.Exited = true
+		d.report(msg)
+	case code == 1 && logLineRe.MatchString(d.last):
+		d.report(RuntimeErrorMsg{
+			Kind:    "log.Fatal",
+			Message: logLineRe.ReplaceAllString(d.last, ""),
+			Output:  d.last,
+			Exited:  true,
+		})
+	}
+	d.last = ""
+	d.idle = nil
+}
+
+// isTraceLine reports whether line can be part of a goroutine dump.
+func isTraceLine(line string) bool {
+	switch {
+	case line == "", strings.HasPrefix(line, "\t"), goroutineHeaderRe.MatchString(line):
+		return true
+	case strings.HasPrefix(line, "panic: "), strings.HasPrefix(line, "[signal "),
+		strings.HasPrefix(line, "created by "), strings.HasPrefix(line, "...additional frames elided..."):
+		return true
+	}
+	// a function call, e.g. main.(*server).handle(0xc000010000, {0x0, 0x0})
+	return strings.HasSuffix(line, ")") && strings.Contains(line, "(") && !strings.Contains(line, " (")
+}
+
+// parseTrace extracts the frames of the first goroutine of trace, which is
+// the one that failed.
+func parseTrace(root, kind, message string, trace []string) RuntimeErrorMsg {
+	msg := RuntimeErrorMsg{Kind: kind, Message: message, Output: strings.Join(trace, "\n")}
+	for i := 0; i < len(trace); i++ {
+		line := trace[i]
+		if goroutineHeaderRe.MatchString(line) {
+			if msg.Goroutine != "" {
+				break
+			}
+			msg.Goroutine = strings.TrimSuffix(line, ":")
+			continue
+		}
+		if msg.Goroutine == "" || line == "" || strings.HasPrefix(line, "\t") || i+1 == len(trace) {
+			continue
+		}
+		m := frameLocationRe.FindStringSubmatch(trace[i+1])
+		if m == nil {
+			continue
+		}
+		i++
+		frame := StackFrame{Function: frameFunction(line), File: m[1]}
+		frame.Line, _ = strconv.Atoi(m[2])
+		if rel, ok := projectPath(root, frame.File); ok {
+			frame.File, frame.Project = rel, true
+		}
+		msg.Frames = append(msg.Frames, frame)
+	}
+	return msg
+}
+
+// frameFunction drops the arguments from a function line of a trace.
+func frameFunction(line string) string {
+	if strings.HasPrefix(line, "created by ") {
+		return line
+	}
+	if i := strings.LastIndex(line, "("); i > 0 && strings.HasSuffix(line, ")") {
+		return line[:i]
+	}
+	return line
+}
+
+// projectPath returns file relative to root if it is one of the project's
+// own files, i.e. inside root but not vendored.
+func projectPath(root, file string) (string, bool) {
+	rel, err := filepath.Rel(root, filepath.FromSlash(file))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	rel = filepath.ToSlash(rel)
+	if strings.HasPrefix(rel, "vendor/") {
+		return "", false
+	}
+	return rel, true
+}
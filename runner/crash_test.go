@@ -0,0 +1,202 @@
+package runner
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const panicTrace = `panic: runtime error: index out of range [3] with length 3
+
+goroutine 1 [running]:
+main.(*server).lookup(0xc000012345, {0x1234, 0x3})
+	/home/dev/app/server.go:42 +0x1d
+github.com/lib/pq.(*conn).query(...)
+	/home/dev/go/pkg/mod/github.com/lib/pq@v1.10.9/conn.go:100
+main.main()
+	/home/dev/app/main.go:12 +0x65
+
+goroutine 18 [chan receive]:
+main.worker()
+	/home/dev/app/worker.go:7 +0x2a
+`
+
+// crashRecorder collects the errors a crashDetector reports.
+type crashRecorder struct {
+	mu   sync.Mutex
+	msgs []RuntimeErrorMsg
+}
+
+func (r *crashRecorder) report(msg RuntimeErrorMsg) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs = append(r.msgs, msg)
+}
+
+func (r *crashRecorder) reports() []RuntimeErrorMsg {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.msgs)
+}
+
+func newTestCrashDetector() (*crashDetector, *crashRecorder) {
+	rec := &crashRecorder{}
+	return newCrashDetector("/home/dev/app", rec.report), rec
+}
+
+func TestCrashDetectorPanic(t *testing.T) {
+	t.Parallel()
+
+	d, rec := newTestCrashDetector()
+	// stderr arrives in arbitrary chunks
+	for _, chunk := range []string{"listening on :8080\n", panicTrace[:50], panicTrace[50:]} {
+		_, err := d.Write([]byte(chunk))
+		require.NoError(t, err)
+	}
+	d.exited(2)
+
+	msgs := rec.reports()
+	require.Len(t, msgs, 1)
+	msg := msgs[0]
+	assert.Equal(t, "panic", msg.Kind)
+	assert.Equal(t, "runtime error: index out of range [3] with length 3", msg.Message)
+	assert.Equal(t, "goroutine 1 [running]", msg.Goroutine)
+	assert.True(t, msg.Exited)
+	assert.Equal(t, []StackFrame{
+		{Function: "main.(*server).lookup", File: "server.go", Line: 42, Project: true},
+		{Function: "github.com/lib/pq.(*conn).query", File: "/home/dev/go/pkg/mod/github.com/lib/pq@v1.10.9/conn.go", Line: 100},
+		{Function: "main.main", File: "main.go", Line: 12, Project: true},
+	}, msg.Frames)
+	assert.Contains(t, msg.Output, "goroutine 18 [chan receive]:")
+}
+
+func TestCrashDetectorRecoveredPanic(t *testing.T) {
+	t.Parallel()
+
+	d, rec := newTestCrashDetector()
+	fmt.Fprint(d, "2026/10/18 12:00:00 http: panic serving 127.0.0.1:50000: boom\n"+
+		"goroutine 7 [running]:\n"+
+		"net/http.(*conn).serve.func1()\n"+
+		"\t/usr/local/go/src/net/http/server.go:1947 +0xbe\n"+
+		"main.handler({0x0, 0x0}, 0xc000100000)\n"+
+		"\t/home/dev/app/handler.go:9 +0x25\n"+
+		"created by net/http.(*Server).Serve in goroutine 1\n"+
+		"\t/usr/local/go/src/net/http/server.go:3285 +0x4b4\n"+
+		"2026/10/18 12:00:01 GET /users 200\n")
+
+	// the next log line ends the trace, the app is still up
+	msgs := rec.reports()
+	require.Len(t, msgs, 1)
+	// the line before a goroutine header may be anything the app printed
+	assert.Equal(t, goroutineDumpMessage, msgs[0].Message)
+	assert.False(t, msgs[0].Exited)
+	require.Len(t, msgs[0].Frames, 3)
+	assert.Equal(t, StackFrame{Function: "main.handler", File: "handler.go", Line: 9, Project: true}, msgs[0].Frames[1])
+	assert.Equal(t, "created by net/http.(*Server).Serve in goroutine 1", msgs[0].Frames[2].Function)
+
+	d.exited(0)
+	assert.Len(t, rec.reports(), 1)
+}
+
+func TestCrashDetectorIdle(t *testing.T) {
+	t.Parallel()
+
+	d, rec := newTestCrashDetector()
+	fmt.Fprint(d, "fatal error: all goroutines are asleep - deadlock!\n\ngoroutine 1 [chan receive]:\nmain.main()\n\t/home/dev/app/main.go:5 +0x2d\n")
+
+	require.Eventually(t, func() bool { return len(rec.reports()) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "fatal error", rec.reports()[0].Kind)
+	assert.Equal(t, "all goroutines are asleep - deadlock!", rec.reports()[0].Message)
+	assert.False(t, rec.reports()[0].Exited)
+
+	// the app exits after its output paused, so the trace was fatal
+	d.exited(2)
+	msgs := rec.reports()
+	require.Len(t, msgs, 2)
+	assert.True(t, msgs[1].Exited)
+	assert.Equal(t, msgs[0].Message, msgs[1].Message)
+
+	t.Run("recovered", func(t *testing.T) {
+		t.Parallel()
+		d, rec := newTestCrashDetector()
+		fmt.Fprint(d, "goroutine 7 [running]:\nmain.handler()\n\t/home/dev/app/handler.go:9 +0x25\n")
+		require.Eventually(t, func() bool { return len(rec.reports()) == 1 }, time.Second, 10*time.Millisecond)
+		// the app went on and was stopped later
+		fmt.Fprint(d, "2026/10/18 12:00:01 GET /users 200\n")
+		d.exited(-1)
+		assert.Len(t, rec.reports(), 1)
+	})
+}
+
+func TestCrashDetectorLogFatal(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		output string
+		code   int
+		expect []RuntimeErrorMsg
+	}{
+		{
+			name:   "log_fatal",
+			output: "2026/10/18 12:00:00 open config.toml: no such file or directory\n",
+			code:   1,
+			expect: []RuntimeErrorMsg{{
+				Kind:    "log.Fatal",
+				Message: "open config.toml: no such file or directory",
+				Output:  "2026/10/18 12:00:00 open config.toml: no such file or directory",
+				Exited:  true,
+			}},
+		},
+		{
+			name:   "killed",
+			output: "2026/10/18 12:00:00 GET /users 200\n",
+			code:   -1,
+		},
+		{
+			name:   "not_a_log_line",
+			output: "usage: app [flags]\n",
+			code:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			d, rec := newTestCrashDetector()
+			fmt.Fprint(d, tt.output)
+			d.exited(tt.code)
+			assert.Equal(t, tt.expect, rec.reports())
+		})
+	}
+}
+
+func TestProxy_appUnreachable(t *testing.T) {
+	proxy := NewProxy(&cfgProxy{Enabled: true, ProxyPort: proxyPort, AppPort: 2222, AppStartTimeout: 1})
+
+	t.Run("page", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", "text/html,application/xhtml+xml")
+		rec := httptest.NewRecorder()
+		proxy.proxyHandler(rec, req)
+
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+		assert.Contains(t, rec.Body.String(), "unable to reach app")
+		assert.Contains(t, rec.Body.String(), ProxyScript)
+	})
+
+	t.Run("api", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		proxy.proxyHandler(rec, httptest.NewRequest("GET", "/api", nil))
+
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.NotContains(t, rec.Body.String(), ProxyScript)
+	})
+}
@@ -0,0 +1,178 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// eventClass is what a watcher event leads to.
+type eventClass int
+
+const (
+	eventIgnored eventClass = iota
+	eventNewDir
+	eventRule
+	eventRebuild
+	// eventRestart restarts the binary without building it, for changes of
+	// env_files.
+	eventRestart
+)
+
+func (c eventClass) String() string {
+	switch c {
+	case eventNewDir:
+		return "new dir"
+	case eventRule:
+		return "rule"
+	case eventRebuild:
+		return "rebuild"
+	case eventRestart:
+		return "restart"
+	default:
+		return "ignored"
+	}
+}
+
+// classifiedEvent is a watcher event with what it leads to.
+type classifiedEvent struct {
+	class eventClass
+	// rule is the index of the matching rule, for eventRule.
+	rule int
+	// by tells what decided the class, for debug logs.
+	by string
+}
+
+// classifyEvent decides what a watcher event leads to. Rules take precedence
+// over the main build: a file matched by a rule runs the rule's cmd and never
+// triggers a rebuild.
+func (e *Engine) classifyEvent(ev fsnotify.Event) classifiedEvent {
+	if !validEvent(ev) {
+		return classifiedEvent{class: eventIgnored, by: ev.Op.String()}
+	}
+	if isDir(ev.Name) {
+		return classifiedEvent{class: eventNewDir}
+	}
+	// env files are often gitignored, but are watched when configured
+	if e.isEnvFile(ev.Name) {
+		return classifiedEvent{class: eventRestart, by: "env_files"}
+	}
+	if e.isIgnored(ev.Name, false) {
+		return classifiedEvent{class: eventIgnored, by: "ignore file"}
+	}
+	if idx, by := e.matchRule(ev.Name); idx >= 0 {
+		return classifiedEvent{class: eventRule, rule: idx, by: by}
+	}
+	if e.isExcludeFile(ev.Name) {
+		return classifiedEvent{class: eventIgnored, by: "exclude_file"}
+	}
+	if by, ok := e.matchExcludeGlob(ev.Name); ok {
+		return classifiedEvent{class: eventIgnored, by: fmt.Sprintf("exclude %q", by)}
+	}
+	if excludeRegex, _ := e.isExcludeRegex(ev.Name); excludeRegex {
+		return classifiedEvent{class: eventIgnored, by: "exclude_regex"}
+	}
+	if e.isIncludeExt(ev.Name) {
+		return classifiedEvent{class: eventRebuild, by: "include_ext"}
+	}
+	if e.checkIncludeFile(ev.Name) {
+		return classifiedEvent{class: eventRebuild, by: "include_file"}
+	}
+	if by, ok := e.matchIncludeGlob(ev.Name); ok {
+		return classifiedEvent{class: eventRebuild, by: fmt.Sprintf("include %q", by)}
+	}
+	return classifiedEvent{class: eventIgnored, by: "no include"}
+}
+
+// startDispatcher starts the goroutine consuming the watcher's events, and
+// the one rescanning after overflows, which run until cleanup closes
+// watcherStopCh.
+func (e *Engine) startDispatcher() {
+	e.dispatcher.Add(2)
+	go func() {
+		defer e.dispatcher.Done()
+		e.dispatchEvents()
+	}()
+	go func() {
+		defer e.dispatcher.Done()
+		e.rescanLoop()
+	}()
+}
+
+func (e *Engine) dispatchEvents() {
+	for {
+		select {
+		case <-e.watcherStopCh:
+			return
+		case ev, ok := <-e.watcher.Events():
+			if !ok {
+				return
+			}
+			e.dispatch(ev)
+		case ev := <-e.missedCh:
+			e.dispatch(ev)
+		case err, ok := <-e.watcher.Errors():
+			if !ok {
+				return
+			}
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				e.watcherLog("too many changes at once, some were missed: rescanning")
+				e.scheduleRescan()
+				break
+			}
+			e.watcherLog("error: %s", err.Error())
+		}
+	}
+}
+
+// dispatch fans a watcher event out to the build, a rule, or the dir walker.
+func (e *Engine) dispatch(ev fsnotify.Event) {
+	e.mainDebug("event: %+v", ev)
+	if base := filepath.Base(ev.Name); validEvent(ev) && (base == gitignoreFile || base == airignoreFile) {
+		e.ignore.forget(filepath.Dir(ev.Name))
+	}
+	c := e.classifyEvent(ev)
+	name := e.config.rel(ev.Name)
+	switch c.class {
+	case eventIgnored:
+		e.watcherDebug("!ignore %s by %s", name, c.by)
+	case eventNewDir:
+		e.watchNewDir(ev.Name, removeEvent(ev))
+	case eventRule:
+		e.stampDispatched(ev.Name)
+		e.watcherDebug("%s matches rule %s by %s", name, e.config.Build.Rules[c.rule].Name, c.by)
+		select {
+		case e.ruleEventChs[c.rule] <- ev:
+		default:
+			// channel full means a run is already queued
+		}
+	case eventRebuild, eventRestart:
+		e.stampDispatched(ev.Name)
+		// Rewatch the file if the editor is using atomic saving.
+		if renameOrRemoveEvent(ev) && e.checkIncludeFile(ev.Name) {
+			go e.rewatchFile(ev.Name)
+		}
+		e.watcherDebug("%s has changed, %s by %s", name, c.class, c.by)
+		select {
+		case e.eventCh <- ev:
+		case <-e.watcherStopCh:
+		}
+	}
+}
+
+// isEnvFile reports whether path is one of the env_files, whose changes only
+// need the binary to restart.
+func (e *Engine) isEnvFile(path string) bool {
+	cleaned := filepath.Clean(path)
+	for _, envPath := range e.config.EnvFiles {
+		if !filepath.IsAbs(envPath) {
+			envPath = filepath.Join(e.config.Root, envPath)
+		}
+		if filepath.Clean(envPath) == cleaned {
+			return true
+		}
+	}
+	return false
+}
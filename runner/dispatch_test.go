@@ -0,0 +1,179 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyEvent(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		".gitignore":     ".env\n*.log\n",
+		".env":           "PORT=8080",
+		"main.go":        "package main",
+		"main_test.go":   "package main",
+		"api/types.go":   "package api",
+		"web/app.css":    "body {}",
+		"app.log":        "",
+		"README.md":      "# app",
+		"config/app.yml": "port: 8080",
+	})
+	cfg := defaultConfig()
+	cfg.Root = root
+	cfg.EnvFiles = []string{".env"}
+	cfg.Build.UseGitignore = true
+	cfg.Build.ExcludeRegex = []string{"_test.go"}
+	cfg.Build.Include = []string{"config/*.yml"}
+	cfg.Build.Exclude = []string{"api/**"}
+	cfg.Build.Rules = []cfgRule{{Name: "css", Cmd: "true", IncludeExt: []string{"css"}}}
+	require.NoError(t, cfg.preprocess(nil))
+	e := &Engine{config: &cfg, ignore: newIgnoreRules(root, true)}
+
+	tests := []struct {
+		name string
+		op   fsnotify.Op
+		want eventClass
+	}{
+		{name: "main.go", op: fsnotify.Write, want: eventRebuild},
+		{name: "main.go", op: fsnotify.Chmod, want: eventIgnored},
+		{name: "main_test.go", op: fsnotify.Write, want: eventIgnored},
+		{name: "api/types.go", op: fsnotify.Write, want: eventIgnored},
+		{name: "config/app.yml", op: fsnotify.Write, want: eventRebuild},
+		{name: "README.md", op: fsnotify.Write, want: eventIgnored},
+		{name: "app.log", op: fsnotify.Create, want: eventIgnored},
+		{name: "web/app.css", op: fsnotify.Write, want: eventRule},
+		{name: "web", op: fsnotify.Create, want: eventNewDir},
+		{name: ".env", op: fsnotify.Write, want: eventRestart},
+	}
+	for _, tt := range tests {
+		ev := fsnotify.Event{Name: filepath.Join(root, filepath.FromSlash(tt.name)), Op: tt.op}
+		assert.Equal(t, tt.want, e.classifyEvent(ev).class, "%s %s", tt.op, tt.name)
+	}
+}
+
+func TestRestartRunSkipsBuild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	root := t.TempDir()
+	built := filepath.Join(root, "built")
+	ran := filepath.Join(root, "ran")
+	writeFiles(t, root, map[string]string{
+		".env":   "AIR_RESTART_TEST=reloaded",
+		"run.sh": "#!/bin/sh\nprintf '%s' \"$AIR_RESTART_TEST\" > " + ran + "\n",
+	})
+	require.NoError(t, os.Chmod(filepath.Join(root, "run.sh"), 0o755))
+	t.Cleanup(func() { _ = os.Unsetenv("AIR_RESTART_TEST") })
+	cfg := defaultConfig()
+	cfg.Root = root
+	cfg.Log.Silent = true
+	cfg.EnvFiles = []string{".env"}
+	cfg.Build.Cmd = "touch " + built
+	cfg.Build.Bin = filepath.Join(root, "run.sh")
+	require.NoError(t, cfg.preprocess(nil))
+	e, err := NewEngineWithConfig(&cfg, false)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		e.stopBin()
+		_ = e.watcher.Close()
+	})
+
+	// env files alone only need a restart, with source changes a rebuild
+	env := fsnotify.Event{Name: filepath.Join(root, ".env"), Op: fsnotify.Write}
+	e.eventCh <- env
+	assert.True(t, e.flushEvents(&changeSet{}))
+	e.eventCh <- env
+	e.eventCh <- fsnotify.Event{Name: filepath.Join(root, "main.go"), Op: fsnotify.Write}
+	assert.False(t, e.flushEvents(&changeSet{}))
+
+	e.restartRun()
+	assert.Eventually(t, func() bool {
+		data, err := os.ReadFile(ran)
+		return err == nil && string(data) == "reloaded"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NoFileExists(t, built)
+}
+
+func TestDispatcherStopsOnce(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"main.go": "package main"})
+	cfg := defaultConfig()
+	cfg.Root = root
+	require.NoError(t, cfg.preprocess(nil))
+	e, err := NewEngineWithConfig(&cfg, false)
+	require.NoError(t, err)
+
+	e.startDispatcher()
+	require.NoError(t, e.watchConfiguredDirs())
+	require.NoError(t, os.WriteFile(filepath.Join(root, "main.go"), []byte("package main\n"), 0o644))
+	select {
+	case ev := <-e.eventCh:
+		assert.Equal(t, filepath.Join(root, "main.go"), ev.Name)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event dispatched")
+	}
+
+	close(e.watcherStopCh)
+	done := make(chan struct{})
+	go func() {
+		e.dispatcher.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("dispatcher did not stop")
+	}
+	require.NoError(t, e.watcher.Close())
+}
+
+// BenchmarkDispatch measures the latency from a file write to its build
+// event on a tree of many dirs, and reports the goroutines watching it.
+func BenchmarkDispatch(b *testing.B) {
+	const dirs = 500
+	root := b.TempDir()
+	for i := 0; i < dirs; i++ {
+		dir := filepath.Join(root, fmt.Sprintf("pkg%d", i))
+		require.NoError(b, os.MkdirAll(dir, 0o755))
+		require.NoError(b, os.WriteFile(filepath.Join(dir, "a.go"), []byte("package pkg"), 0o644))
+	}
+	cfg := defaultConfig()
+	cfg.Root = root
+	cfg.Log.Silent = true
+	require.NoError(b, cfg.preprocess(nil))
+	e, err := NewEngineWithConfig(&cfg, false)
+	require.NoError(b, err)
+	before := runtime.NumGoroutine()
+	e.startDispatcher()
+	require.NoError(b, e.watchConfiguredDirs())
+	goroutines := runtime.NumGoroutine() - before
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		name := filepath.Join(root, fmt.Sprintf("pkg%d", i%dirs), "a.go")
+		require.NoError(b, os.WriteFile(name, []byte("package pkg\n"), 0o644))
+		for got := ""; got != name; {
+			select {
+			case ev := <-e.eventCh:
+				got = ev.Name
+			case <-time.After(5 * time.Second):
+				b.Fatal("no event dispatched")
+			}
+		}
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(goroutines), "goroutines")
+
+	close(e.watcherStopCh)
+	e.dispatcher.Wait()
+	_ = e.watcher.Close()
+}
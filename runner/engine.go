@@ -1,6 +1,7 @@
 package runner
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -13,6 +14,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/gohugoio/hugo/watcher/filenotify"
 	"github.com/joho/godotenv"
 )
@@ -29,9 +31,13 @@ type Engine struct {
 	runArgs   []string
 	running   atomic.Bool
 
-	eventCh       chan string
-	ruleEventChs  []chan string
-	watcherStopCh chan bool
+	eventCh       chan fsnotify.Event
+	ruleEventChs  []chan fsnotify.Event
+	watcherStopCh chan struct{} // closed to stop the event dispatcher
+	dispatcher    sync.WaitGroup
+	rescanCh      chan struct{}
+	// missedCh carries the events synthesized by rescans to the dispatcher.
+	missedCh chan fsnotify.Event
 	// buildRunCh serves dual purpose:
 	// 1. As a semaphore ensuring only one build runs at a time (buffer size 1)
 	// 2. Carries each build's unique stop channel for cancellation
@@ -46,8 +52,24 @@ type Engine struct {
 	exitCh    chan bool
 
 	mu            sync.RWMutex
-	watchers      uint
 	fileChecksums *checksumMap
+	ignore        *ignoreRules
+	// watched holds the paths registered with the watcher, and syncedAt the
+	// time since which changes may have been missed. dispatched holds the
+	// stat of the files whose events were dispatched, which rescans skip
+	// unless they changed again.
+	watched       map[string]struct{}
+	syncedAt      time.Time
+	dispatched    map[string]fileStamp
+	watchLimitHit atomic.Bool
+	mounts        []mountPoint
+	warnedMounts  map[string]struct{}
+	// lastChangesEnv tells post_cmd about the changes of the last build.
+	lastChangesEnv []string
+	// lastSession holds the checksums saved by the last session during the
+	// startup, and startChanges the files changed since for the first build.
+	lastSession  *lastSession
+	startChanges *changeSet
 
 	ll sync.Mutex // lock for logger
 
@@ -74,9 +96,9 @@ func NewEngineWithConfig(cfg *Config, debugMode bool) (*Engine, error) {
 		runArgs = append(runArgs, entryArgs...)
 	}
 	runArgs = append(runArgs, cfg.Build.ArgsBin...)
-	ruleEventChs := make([]chan string, len(cfg.Build.Rules))
+	ruleEventChs := make([]chan fsnotify.Event, len(cfg.Build.Rules))
 	for i := range ruleEventChs {
-		ruleEventChs[i] = make(chan string, 100)
+		ruleEventChs[i] = make(chan fsnotify.Event, 100)
 	}
 	e := Engine{
 		config:        cfg,
@@ -86,15 +108,22 @@ func NewEngineWithConfig(cfg *Config, debugMode bool) (*Engine, error) {
 		watcher:       watcher,
 		debugMode:     debugMode,
 		runArgs:       runArgs,
-		eventCh:       make(chan string, 1000),
+		eventCh:       make(chan fsnotify.Event, 1000),
 		ruleEventChs:  ruleEventChs,
-		watcherStopCh: make(chan bool, 10),
+		watcherStopCh: make(chan struct{}),
+		rescanCh:      make(chan struct{}, 1),
+		missedCh:      make(chan fsnotify.Event),
 		buildRunCh:    make(chan chan struct{}, 1),
 		exitCh:        make(chan bool),
 		fileChecksums: &checksumMap{m: make(map[string]string)},
-		watchers:      0,
+		ignore:        newIgnoreRules(cfg.Root, cfg.Build.UseGitignore),
+		watched:       make(map[string]struct{}),
+		dispatched:    make(map[string]fileStamp),
+		mounts:        readMounts(),
+		warnedMounts:  make(map[string]struct{}),
 		globalEnv:     map[string]*string{},
 	}
+	e.proxy.logf = e.proxyLog
 
 	return &e, nil
 }
@@ -126,6 +155,7 @@ func (e *Engine) Run() {
 	if err = e.checkRunEnv(); err != nil {
 		os.Exit(1)
 	}
+	e.startDispatcher()
 	if err = e.watchConfiguredDirs(); err != nil {
 		os.Exit(1)
 	}
@@ -146,7 +176,7 @@ func (e *Engine) checkRunEnv() error {
 	return nil
 }
 
-func (e *Engine) watchConfiguredDirs() error {
+func (e *Engine) watchConfiguredDirs() (err error) {
 	type watchTarget struct {
 		path     string
 		optional bool
@@ -161,6 +191,20 @@ func (e *Engine) watchConfiguredDirs() error {
 		}
 	}
 
+	if e.config.Build.ExcludeUnchanged {
+		e.loadChecksums()
+	}
+	r := e.newRegistration()
+	e.withLock(func() {
+		e.syncedAt = r.start
+	})
+	defer func() {
+		elapsed := r.wait()
+		e.startChanges = e.endLastSession()
+		if err == nil {
+			e.watcherLog("watching %d dirs and %d files in %s", len(r.dirs), r.files, elapsed.Round(time.Millisecond))
+		}
+	}()
 	seen := make(map[string]struct{}, len(targets))
 	for _, target := range targets {
 		if target.path == "" {
@@ -177,22 +221,107 @@ func (e *Engine) watchConfiguredDirs() error {
 			}
 			return err
 		}
-		if err := e.watching(cleaned); err != nil {
+		if err := e.register(r, cleaned); err != nil {
 			return err
 		}
 		seen[cleaned] = struct{}{}
 	}
-	return nil
+	// check up front whether the dirs fit in the system's watch limit, and
+	// poll those which don't. Dirs created later fall back to polling when
+	// adding them fails.
+	for _, dir := range e.planPolling(r.pending) {
+		e.pollDir(dir)
+	}
+	return e.addWatches(r)
+}
+
+// registration is one walk registering dirs with the watcher. With
+// exclude_unchanged, the files found on the way are handed to a bounded pool
+// of workers seeding the checksum cache, so every file is hashed once.
+type registration struct {
+	start time.Time
+	// dirs holds the registered dirs, so that overlapping include dirs are
+	// walked once.
+	dirs  map[string]struct{}
+	files int
+	// pending holds the dirs to add to the watcher, in walk order. They are
+	// added once the walk is done and their count known.
+	pending []string
+
+	checksums chan string
+	wg        sync.WaitGroup
+	// onFile, if set, is called with each file of the registered dirs.
+	onFile func(path string, info os.FileInfo)
+}
+
+// checksumWorkers is the size of the pool hashing files at startup.
+func checksumWorkers() int {
+	return max(runtime.GOMAXPROCS(0), 4)
+}
+
+func (e *Engine) newRegistration() *registration {
+	r := &registration{start: time.Now(), dirs: make(map[string]struct{})}
+	if e.config.Build.ExcludeUnchanged {
+		n := checksumWorkers()
+		r.checksums = make(chan string, n*4)
+		r.wg.Add(n)
+		for i := 0; i < n; i++ {
+			go func() {
+				defer r.wg.Done()
+				for path := range r.checksums {
+					e.primeChecksum(path)
+				}
+			}()
+		}
+	}
+	return r
+}
+
+// wait waits for the queued checksums, and returns the time the
+// registration took.
+func (r *registration) wait() time.Duration {
+	if r.checksums != nil {
+		close(r.checksums)
+		r.wg.Wait()
+	}
+	return time.Since(r.start)
 }
 
+// watching registers root and the dirs under it with the watcher.
 func (e *Engine) watching(root string) error {
+	return e.watchTree(e.newRegistration(), root)
+}
+
+// watchTree walks root with r, adds the dirs found to the watcher, and waits
+// for the checksums.
+func (e *Engine) watchTree(r *registration, root string) error {
+	err := e.register(r, root)
+	if err == nil {
+		err = e.addWatches(r)
+	}
+	r.wait()
+	return err
+}
+
+// addWatches adds the dirs found by the walk to the watcher.
+func (e *Engine) addWatches(r *registration) error {
+	for _, dir := range r.pending {
+		if err := e.watchPath(dir); err != nil {
+			return err
+		}
+	}
+	r.pending = nil
+	return nil
+}
+
+func (e *Engine) register(r *registration, root string) error {
 	return filepath.Walk(root, func(path string, info os.FileInfo, _ error) error {
 		// NOTE: path is absolute
 		if info != nil && !info.IsDir() {
-			if e.checkIncludeFile(path) {
-				return e.watchPath(path)
-			}
-			return nil
+			return e.registerFile(r, path, info)
+		}
+		if _, ok := r.dirs[path]; ok {
+			return filepath.SkipDir
 		}
 		// exclude tmp dir
 		if e.isTmpDir(path) {
@@ -210,10 +339,14 @@ func (e *Engine) watching(root string) error {
 		}
 		// exclude user specified directories, except a rule's own include_dir:
 		// rules watch their dirs even when the main build excludes them
-		if e.isExcludeDir(path) && !e.isRuleDir(path) {
+		if (e.isExcludeDir(path) || e.isExcludeGlobDir(path)) && !e.isRuleDir(path) {
 			e.watcherLog("!exclude %s", e.config.rel(path))
 			return filepath.SkipDir
 		}
+		if e.isIgnored(path, true) {
+			e.watcherLog("!ignore %s", e.config.rel(path))
+			return filepath.SkipDir
+		}
 		isIn, walkDir := e.checkIncludeDir(path)
 		if e.inRuleDir(path) {
 			isIn, walkDir = true, true
@@ -223,70 +356,68 @@ func (e *Engine) watching(root string) error {
 			return filepath.SkipDir
 		}
 		if isIn {
-			return e.watchPath(path)
+			r.dirs[path] = struct{}{}
+			r.pending = append(r.pending, path)
 		}
 		return nil
 	})
 }
 
-// cacheFileChecksums calculates and stores checksums for each non-excluded file it finds from root.
-func (e *Engine) cacheFileChecksums(root string) error {
-	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			if info == nil {
-				return err
-			}
-			if info.IsDir() {
-				return filepath.SkipDir
-			}
+// registerFile watches the include_file files and symlinked dirs found by the
+// walk, and queues the files of watched dirs for checksumming.
+func (e *Engine) registerFile(r *registration, path string, info os.FileInfo) error {
+	if e.checkIncludeFile(path) {
+		if err := e.watchPath(path); err != nil {
 			return err
 		}
-
-		if !info.Mode().IsRegular() {
-			if e.isTmpDir(path) || e.isTestDataDir(path) || isHiddenDirectory(path) || e.isExcludeDir(path) {
-				e.watcherDebug("!exclude checksum %s", e.config.rel(path))
-				return filepath.SkipDir
-			}
-
-			// Follow symbolic link
-			if e.config.Build.FollowSymlink && (info.Mode()&os.ModeSymlink) > 0 {
-				link, err := filepath.EvalSymlinks(path)
-				if err != nil {
-					return err
-				}
-				linkInfo, err := os.Stat(link)
-				if err != nil {
-					return err
-				}
-				if linkInfo.IsDir() {
-					err = e.watchPath(link)
-					if err != nil {
-						return err
-					}
-				}
-				return nil
-			}
-		}
-
-		if e.isExcludeFile(path) || !e.isIncludeExt(path) && !e.checkIncludeFile(path) {
-			e.watcherDebug("!exclude checksum %s", e.config.rel(path))
-			return nil
+	}
+	// Follow symbolic link
+	if e.config.Build.FollowSymlink && info.Mode()&os.ModeSymlink != 0 {
+		link, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return err
 		}
-
-		excludeRegex, err := e.isExcludeRegex(path)
+		linkInfo, err := os.Stat(link)
 		if err != nil {
 			return err
 		}
-		if excludeRegex {
-			e.watcherDebug("!exclude checksum %s", e.config.rel(path))
+		if linkInfo.IsDir() {
+			if _, ok := r.dirs[link]; ok {
+				return nil
+			}
+			r.dirs[link] = struct{}{}
+			r.pending = append(r.pending, link)
 			return nil
 		}
-
-		// update the checksum cache for the current file
-		e.primeChecksum(path)
-
+	}
+	if _, ok := r.dirs[filepath.Dir(path)]; !ok {
 		return nil
-	})
+	}
+	r.files++
+	if r.onFile != nil {
+		r.onFile(path, info)
+	}
+	if r.checksums == nil {
+		return nil
+	}
+	if e.isIgnored(path, false) || e.isExcludeFile(path) || !e.isIncluded(path) {
+		e.watcherDebug("!exclude checksum %s", e.config.rel(path))
+		return nil
+	}
+	if _, excluded := e.matchExcludeGlob(path); excluded {
+		e.watcherDebug("!exclude checksum %s", e.config.rel(path))
+		return nil
+	}
+	excludeRegex, err := e.isExcludeRegex(path)
+	if err != nil {
+		return err
+	}
+	if excludeRegex {
+		e.watcherDebug("!exclude checksum %s", e.config.rel(path))
+		return nil
+	}
+	r.checksums <- path
+	return nil
 }
 
 func (e *Engine) rewatchFile(name string) {
@@ -307,76 +438,27 @@ func (e *Engine) rewatchFile(name string) {
 }
 
 func (e *Engine) watchPath(path string) error {
-	if err := e.watcher.Add(path); err != nil {
+	err := e.watcher.Add(path)
+	if errors.Is(err, errWatchLimit) && e.pollDir(path) {
+		if e.watchLimitHit.CompareAndSwap(false, true) {
+			e.watcherLog("out of inotify watches, polling %s and the dirs that don't fit from now on. %s", e.config.rel(path), raiseWatchLimitHint(0))
+		}
+		err = e.watcher.Add(path)
+	}
+	if err != nil {
+		if errors.Is(err, errWatchLimit) {
+			// keep watching what we can rather than failing
+			if e.watchLimitHit.CompareAndSwap(false, true) {
+				e.watcherLog("can't watch %s and further dirs, changes there are missed: %s", e.config.rel(path), err.Error())
+			}
+			return nil
+		}
 		e.watcherLog("failed to watch %s, error: %s", path, err.Error())
 		return err
 	}
+	e.addWatched(path)
 	e.watcherLog("watching %s", e.config.rel(path))
-
-	go func() {
-		e.withLock(func() {
-			e.watchers++
-		})
-		defer func() {
-			e.withLock(func() {
-				e.watchers--
-			})
-		}()
-
-		if e.config.Build.ExcludeUnchanged {
-			err := e.cacheFileChecksums(path)
-			if err != nil {
-				e.watcherLog("error building checksum cache: %v", err)
-			}
-		}
-
-		for {
-			select {
-			case <-e.watcherStopCh:
-				return
-			case ev := <-e.watcher.Events():
-				e.mainDebug("event: %+v", ev)
-				if !validEvent(ev) {
-					break
-				}
-				if isDir(ev.Name) {
-					e.watchNewDir(ev.Name, removeEvent(ev))
-					break
-				}
-				// rules take precedence: a file matched by a rule runs the
-				// rule's cmd and never triggers a rebuild
-				if idx := e.matchRuleIndex(ev.Name); idx >= 0 {
-					e.watcherDebug("%s matches rule %s", e.config.rel(ev.Name), e.config.Build.Rules[idx].Name)
-					select {
-					case e.ruleEventChs[idx] <- ev.Name:
-					default:
-						// channel full means a run is already queued
-					}
-					break
-				}
-				if e.isExcludeFile(ev.Name) {
-					break
-				}
-				excludeRegex, _ := e.isExcludeRegex(ev.Name)
-				if excludeRegex {
-					break
-				}
-				if !e.isIncludeExt(ev.Name) && !e.checkIncludeFile(ev.Name) {
-					break
-				}
-				// Rewatch the file if the editor is using atomic saving.
-				if renameOrRemoveEvent(ev) {
-					if e.checkIncludeFile(ev.Name) {
-						go e.rewatchFile(ev.Name)
-					}
-				}
-				e.watcherDebug("%s has changed", e.config.rel(ev.Name))
-				e.eventCh <- ev.Name
-			case err := <-e.watcher.Errors():
-				e.watcherLog("error: %s", err.Error())
-			}
-		}
-	}()
+	e.checkMount(path)
 	return nil
 }
 
@@ -387,14 +469,19 @@ func (e *Engine) watchNewDir(dir string, removeDir bool) {
 	if e.isTestDataDir(dir) {
 		return
 	}
-	if isHiddenDirectory(dir) || (e.isExcludeDir(dir) && !e.isRuleDir(dir)) {
+	if isHiddenDirectory(dir) || ((e.isExcludeDir(dir) || e.isExcludeGlobDir(dir)) && !e.isRuleDir(dir)) {
 		e.watcherLog("!exclude %s", e.config.rel(dir))
 		return
 	}
+	if e.isIgnored(dir, true) {
+		e.watcherLog("!ignore %s", e.config.rel(dir))
+		return
+	}
 	if removeDir {
 		if err := e.watcher.Remove(dir); err != nil {
 			e.watcherLog("failed to stop watching %s, error: %s", dir, err.Error())
 		}
+		e.forgetWatched(dir)
 		return
 	}
 	go func(dir string) {
@@ -406,24 +493,58 @@ func (e *Engine) watchNewDir(dir string, removeDir bool) {
 
 // primeChecksum records a file's current checksum without waiting for any
 // in-flight write. Used when seeding the cache at startup, where nothing is
-// being written and every wait would be pure startup latency.
+// being written and every wait would be pure startup latency. Files unchanged
+// since the last session take their saved checksum.
 func (e *Engine) primeChecksum(filename string) {
-	checksum, err := fileChecksum(filename)
+	info, err := os.Stat(filename)
 	if err != nil {
 		e.watcherDebug("can't cache checksum for %s: %v", e.config.rel(filename), err)
 		return
 	}
-	e.fileChecksums.updateFileChecksum(filename, checksum)
+	session, rel := e.session(), e.config.rel(filename)
+	if checksum, ok := session.checksum(rel, info); ok {
+		e.fileChecksums.store(filename, checksum, info)
+		return
+	}
+	checksum, err := e.checksumFunc(filename)(filename)
+	if err != nil {
+		e.watcherDebug("can't cache checksum for %s: %v", rel, err)
+		return
+	}
+	e.fileChecksums.store(filename, checksum, info)
+	session.hashed(rel, checksum)
+}
+
+// isSemantic reports whether the checksum of filename ignores changes which
+// don't change the program.
+func (e *Engine) isSemantic(filename string) bool {
+	return e.config.Build.SemanticChecksum && filepath.Ext(filename) == ".go"
+}
+
+// checksumFunc returns the function computing the checksum of filename.
+func (e *Engine) checksumFunc(filename string) func(string) (string, error) {
+	if e.isSemantic(filename) {
+		return semanticChecksum
+	}
+	return fileChecksum
 }
 
 func (e *Engine) isModified(filename string) bool {
-	newChecksum, err := settledFileChecksum(filename)
+	// stat first, so that a write racing the hash changes the stamp saved
+	info, statErr := os.Stat(filename)
+	newChecksum, err := settledChecksum(e.checksumFunc(filename), filename)
 	if err != nil {
 		e.watcherDebug("can't determine if file was changed: %v - assuming it did without updating cache", err)
 		return true
 	}
 
-	if e.fileChecksums.updateFileChecksum(filename, newChecksum) {
+	var modified bool
+	if statErr == nil {
+		modified = e.fileChecksums.store(filename, newChecksum, info)
+	} else {
+		modified = e.fileChecksums.updateFileChecksum(filename, newChecksum)
+	}
+	if modified {
 		e.watcherDebug("stored checksum for %s: %s", e.config.rel(filename), newChecksum)
 		return true
 	}
@@ -435,7 +556,7 @@ func (e *Engine) isModified(filename string) bool {
 func (e *Engine) start() {
 	if e.config.Proxy.Enabled {
 		go e.proxy.Run()
-		e.mainLog("Proxy server listening on http://localhost%s", e.proxy.server.Addr)
+		e.mainLog("Proxy server listening on %s", e.proxy.URL())
 	}
 
 	e.running.Store(true)
@@ -447,28 +568,30 @@ func (e *Engine) start() {
 	firstRunCh := make(chan bool, 1)
 	firstRunCh <- true
 
+	// the changes of the last cycle, which a cancelled build hands over
+	var prevChanges *changeSet
 	for {
-		var filename string
+		var changes *changeSet
+		restartOnly := false
 
 		select {
 		case <-e.exitCh:
 			e.mainDebug("exit in start")
 			return
-		case filename = <-e.eventCh:
-			if !e.isIncludeExt(filename) && !e.checkIncludeFile(filename) {
+		case ev := <-e.eventCh:
+			restartOnly = e.isEnvFile(ev.Name)
+			if !e.acceptEvent(ev) {
 				continue
 			}
-			if e.config.Build.ExcludeUnchanged {
-				if !e.isModified(filename) {
-					e.mainLog("skipping %s because contents unchanged", e.config.rel(filename))
-					continue
-				}
-			}
+			changes = &changeSet{}
+			changes.add(e.config.rel(ev.Name), ev.Op)
 
 			// cannot set buildDelay to 0, because when the write multiple events received in short time
 			// it will start Multiple buildRuns: https://github.com/air-verse/air/issues/473
 			time.Sleep(e.config.buildDelay())
-			e.flushEvents()
+			if !e.flushEvents(changes) {
+				restartOnly = false
+			}
 
 			if e.config.Screen.ClearOnRebuild {
 				if e.config.Screen.KeepScroll {
@@ -480,9 +603,12 @@ func (e *Engine) start() {
 				}
 			}
 
-			e.mainLog("%s has changed", e.config.rel(filename))
+			e.mainLog("%s", changes.summary())
 		case <-firstRunCh:
-			// go down
+			// go down, with the files changed since the last session
+			if changes = e.startChanges; changes.len() > 0 {
+				e.mainLog("%s since the last run", changes.summary())
+			}
 		}
 
 		// Stop any currently running build by closing its stop channel
@@ -490,11 +616,19 @@ func (e *Engine) start() {
 		case oldStopCh := <-e.buildRunCh:
 			// Close the old build's stop channel to signal it to stop
 			close(oldStopCh)
+			// the cancelled build may have changes to pick up
+			restartOnly = false
+			changes = changes.merge(prevChanges)
 		default:
 			// No build is currently running
 		}
+		prevChanges = changes
 
-		go e.buildRun()
+		if restartOnly {
+			go e.restartRun()
+		} else {
+			go e.buildRun(changes)
+		}
 	}
 }
 
@@ -564,7 +698,9 @@ func (e *Engine) loadEnvFile() {
 	e.loadedEnv = newEnv
 }
 
-func (e *Engine) buildRun() {
+// buildRun builds and runs the binary, telling the commands about the changes
+// which triggered the build.
+func (e *Engine) buildRun(changes *changeSet) {
 	// Create this build's unique stop channel
 	myStopCh := make(chan struct{})
 
@@ -588,31 +724,41 @@ func (e *Engine) buildRun() {
 
 	e.loadEnvFile()
 
+	env := e.changesEnv(changes, changedFilesJSON)
+	e.withLock(func() {
+		e.lastChangesEnv = env
+	})
+
+	if e.config.Proxy.Enabled {
+		e.proxy.BuildStarted()
+	}
+
 	var err error
-	if err = e.runPreCmd(); err != nil {
+	if err = e.runPreCmd(env...); err != nil {
 		e.runnerLog("failed to execute pre_cmd: %s", err.Error())
+		e.buildFailed(BuildFailedMsg{
+			Error:   err.Error(),
+			Command: strings.Join(e.config.Build.PreCmd, " && "),
+		})
 		if e.config.Build.StopOnError {
 			e.stopBin()
 			return
 		}
 	}
-	if output, err := e.building(); err != nil {
+	if output, err := e.building(env...); err != nil {
 		e.buildLog("failed to build, error: %s", err.Error())
 		_ = e.writeBuildErrorLog(err.Error())
+		e.buildFailed(BuildFailedMsg{
+			Error:   err.Error(),
+			Command: e.config.Build.Cmd,
+			Output:  output,
+		})
 		if e.config.Build.StopOnError {
-			// It only makes sense to run it if we stop on error. Otherwise when
-			// running the binary again the error modal will be overwritten by
-			// the reload.
 			e.stopBin()
-			if e.config.Proxy.Enabled {
-				e.proxy.BuildFailed(BuildFailedMsg{
-					Error:   err.Error(),
-					Command: e.config.Build.Cmd,
-					Output:  output,
-				})
-			}
 			return
 		}
+	} else if e.config.Proxy.Enabled {
+		e.proxy.BuildSucceeded()
 	}
 
 	// Check again before running the binary
@@ -632,6 +778,43 @@ func (e *Engine) buildRun() {
 	}
 }
 
+// buildFailed tells the proxy's clients that the build failed. The error modal
+// is only shown if we stop on error. Otherwise when running the binary again
+// the modal would be overwritten by the reload.
+func (e *Engine) buildFailed(msg BuildFailedMsg) {
+	if !e.config.Proxy.Enabled {
+		return
+	}
+	msg.Overlay = e.config.Build.StopOnError
+	e.proxy.BuildFailed(msg)
+}
+
+// restartRun reloads the env files and restarts the binary without building
+// it again.
+func (e *Engine) restartRun() {
+	myStopCh := make(chan struct{})
+	e.buildRunCh <- myStopCh
+	defer func() {
+		<-e.buildRunCh
+	}()
+
+	select {
+	case <-myStopCh:
+		return
+	case <-e.exitCh:
+		e.mainDebug("exit in restartRun")
+		return
+	default:
+	}
+
+	e.loadEnvFile()
+	e.mainLog("restarting without rebuilding")
+	e.stopBin()
+	if err := e.runBin(); err != nil {
+		e.runnerLog("failed to run, error: %s", err.Error())
+	}
+}
+
 func shouldStopBinBeforeBuild(goos string) bool {
 	return goos == PlatformWindows
 }
@@ -644,20 +827,49 @@ func (e *Engine) stopBinBeforeBuildIfNeeded(goos string) {
 	}
 }
 
-func (e *Engine) flushEvents() {
+// acceptEvent reports whether ev changes a file which the build or a restart
+// needs, skipping unchanged contents with exclude_unchanged.
+func (e *Engine) acceptEvent(ev fsnotify.Event) bool {
+	if !e.isEnvFile(ev.Name) && !e.isIncluded(ev.Name) {
+		return false
+	}
+	if e.config.Build.ExcludeUnchanged && !e.isModified(ev.Name) {
+		if e.isSemantic(ev.Name) {
+			e.mainLog("skipping %s: no semantic change", e.config.rel(ev.Name))
+		} else {
+			e.mainLog("skipping %s because contents unchanged", e.config.rel(ev.Name))
+		}
+		return false
+	}
+	return true
+}
+
+// flushEvents adds the queued events to changes, and reports whether they
+// only changed env files.
+func (e *Engine) flushEvents(changes *changeSet) (restartOnly bool) {
+	restartOnly = true
 	for {
 		select {
-		case <-e.eventCh:
+		case ev := <-e.eventCh:
 			e.mainDebug("flushing events")
+			// more events of a changed file, as editors write in several steps
+			name := e.config.rel(ev.Name)
+			if !changes.has(name) && !e.acceptEvent(ev) {
+				continue
+			}
+			changes.add(name, ev.Op)
+			if !e.isEnvFile(ev.Name) {
+				restartOnly = false
+			}
 		default:
-			return
+			return restartOnly
 		}
 	}
 }
 
 // utility to execute commands, such as cmd & pre_cmd
-func (e *Engine) runCommand(command string) error {
-	cmd, stdout, stderr, err := e.startCmd(command)
+func (e *Engine) runCommand(command string, env ...string) error {
+	cmd, stdout, stderr, err := e.startCmd(command, env...)
 	if err != nil {
 		return err
 	}
@@ -673,10 +885,10 @@ func (e *Engine) runCommand(command string) error {
 	return cmd.Wait()
 }
 
-func (e *Engine) runCommandCopyOutput(command string) (string, error) {
+func (e *Engine) runCommandCopyOutput(command string, env ...string) (string, error) {
 	// both stdout and stderr are piped to the same buffer, so ignore the second
 	// one
-	cmd, stdout, _, err := e.startCmd(command)
+	cmd, stdout, _, err := e.startCmd(command, env...)
 	if err != nil {
 		return "", err
 	}
@@ -696,9 +908,9 @@ func (e *Engine) runCommandCopyOutput(command string) (string, error) {
 }
 
 // run cmd option in .air.toml
-func (e *Engine) building() (string, error) {
+func (e *Engine) building(env ...string) (string, error) {
 	e.buildLog("building...")
-	output, err := e.runCommandCopyOutput(e.config.Build.Cmd)
+	output, err := e.runCommandCopyOutput(e.config.Build.Cmd, env...)
 	if err != nil {
 		return output, err
 	}
@@ -706,10 +918,10 @@ func (e *Engine) building() (string, error) {
 }
 
 // run pre_cmd option in .air.toml
-func (e *Engine) runPreCmd() error {
+func (e *Engine) runPreCmd(env ...string) error {
 	for _, command := range e.config.Build.PreCmd {
 		e.runnerLog("> %s", command)
-		err := e.runCommand(command)
+		err := e.runCommand(command, env...)
 		if err != nil {
 			return err
 		}
@@ -718,10 +930,10 @@ func (e *Engine) runPreCmd() error {
 }
 
 // run post_cmd option in .air.toml
-func (e *Engine) runPostCmd() error {
+func (e *Engine) runPostCmd(env ...string) error {
 	for _, command := range e.config.Build.PostCmd {
 		e.runnerLog("> %s", command)
-		err := e.runCommand(command)
+		err := e.runCommand(command, env...)
 		if err != nil {
 			return err
 		}
@@ -800,6 +1012,9 @@ func (e *Engine) runBin() error {
 			default:
 				formattedBin := formatPath(e.config.runnerBin())
 				command := strings.Join(append([]string{formattedBin}, e.runArgs...), " ")
+				if e.config.Proxy.Enabled {
+					e.proxy.AppStarting()
+				}
 				cmd, stdout, stderr, err := e.startCmd(command)
 				if err != nil {
 					e.mainLog("failed to start %s, error: %s", e.config.rel(e.config.binPath()), err.Error())
@@ -812,17 +1027,37 @@ func (e *Engine) runBin() error {
 				if e.config.Proxy.Enabled {
 					e.mainDebug("reloading proxy")
 					e.proxy.Reload()
+					go e.proxy.WaitAppReady(processExit)
 				}
 
 				e.withLock(func() {
 					e.binStopCh = killFunc(cmd, stdout, stderr, killCh, processExit)
 				})
 
+				// with the proxy, panics in the app's stderr are shown in the browser
+				var appStderr io.Writer = os.Stderr
+				var crash *crashDetector
+				if e.config.Proxy.Enabled {
+					crash = newCrashDetector(e.config.Root, e.proxy.RuntimeError)
+					appStderr = io.MultiWriter(os.Stderr, crash)
+				}
+				stderrDone := make(chan struct{})
 				go copyOutput(os.Stdout, stdout)
-				go copyOutput(os.Stderr, stderr)
+				go func() {
+					copyOutput(appStderr, stderr)
+					close(stderrDone)
+				}()
 
 				state, _ := cmd.Process.Wait()
 				close(processExit)
+				if crash != nil {
+					// the end of a trace may still be in the pipe
+					select {
+					case <-stderrDone:
+					case <-time.After(crashIdle):
+					}
+					crash.exited(state.ExitCode())
+				}
 
 				switch state.ExitCode() {
 				case 0:
@@ -886,11 +1121,8 @@ func (e *Engine) cleanup() {
 	e.stopBin()
 	e.mainDebug("waiting for close watchers..")
 
-	e.withLock(func() {
-		for i := 0; i < int(e.watchers); i++ {
-			e.watcherStopCh <- true
-		}
-	})
+	close(e.watcherStopCh)
+	e.dispatcher.Wait()
 
 	e.mainDebug("waiting for buildRun...")
 	var err error
@@ -900,6 +1132,12 @@ func (e *Engine) cleanup() {
 
 	e.mainDebug("waiting for clean ...")
 
+	if e.config.Build.ExcludeUnchanged && !e.config.Misc.CleanOnExit {
+		if err = e.saveChecksums(); err != nil {
+			e.mainLog("failed to save checksums, error: %s", err.Error())
+		}
+	}
+
 	if e.config.Misc.CleanOnExit {
 		e.mainLog("deleting %s", e.config.tmpPath())
 		if err = os.RemoveAll(e.config.tmpPath()); err != nil {
@@ -912,7 +1150,11 @@ func (e *Engine) cleanup() {
 
 // Stop the air
 func (e *Engine) Stop() {
-	if err := e.runPostCmd(); err != nil {
+	var env []string
+	e.withLock(func() {
+		env = e.lastChangesEnv
+	})
+	if err := e.runPostCmd(env...); err != nil {
 		e.runnerLog("failed to execute post_cmd, error: %s", err.Error())
 	}
 	close(e.exitCh)
@@ -70,6 +70,42 @@ func TestWatching(t *testing.T) {
 	}
 }
 
+func TestWatchingRegistersTreeOnce(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"main.go":            "package main",
+		"README.md":          "# app",
+		"api/api.go":         "package api",
+		"api/v1/types.go":    "package v1",
+		"api/v1/empty.go":    "",
+		"tmp/main":           "bin",
+		"vendor/x/x.go":      "package x",
+		".git/HEAD":          "ref",
+		"web/src/index.html": "<html></html>",
+	})
+	cfg := defaultConfig()
+	cfg.Root = root
+	cfg.Build.ExcludeUnchanged = true
+	cfg.Build.ExcludeDir = []string{"tmp", "vendor"}
+	require.NoError(t, cfg.preprocess(nil))
+	e, err := NewEngineWithConfig(&cfg, false)
+	require.NoError(t, err)
+
+	r := e.newRegistration()
+	require.NoError(t, e.register(r, root))
+	// an include dir inside an already registered tree is not walked again
+	require.NoError(t, e.register(r, filepath.Join(root, "api")))
+	r.wait()
+
+	assert.Len(t, r.dirs, 5)
+	assert.Equal(t, 6, r.files)
+	var cached []string
+	for name := range e.fileChecksums.m {
+		cached = append(cached, filepath.ToSlash(cfg.rel(name)))
+	}
+	assert.ElementsMatch(t, []string{"main.go", "api/api.go", "api/v1/types.go", "web/src/index.html"}, cached)
+}
+
 func TestRegexes(t *testing.T) {
 	engine, err := NewEngine("", nil, true)
 	if err != nil {
@@ -293,7 +329,7 @@ func TestBuildRunStopsExistingBinAfterSuccessfulBuild(t *testing.T) {
 	}()
 	defer engine.stopBin()
 
-	engine.buildRun()
+	engine.buildRun(nil)
 
 	select {
 	case <-stopped:
@@ -337,7 +373,7 @@ func TestBuildRunKeepsFreshBinaryAfterSuccessfulBuildWithStopOnError(t *testing.
 	}, "initial binary process to start")
 	require.NoError(t, err)
 
-	engine.buildRun()
+	engine.buildRun(nil)
 
 	err = waitForCondition(t, time.Second, func() bool {
 		started := false
@@ -395,7 +431,7 @@ func main() {
 	}, "initial issue #910 binary process to start")
 	require.NoError(t, err)
 
-	engine.buildRun()
+	engine.buildRun(nil)
 
 	err = waitForCondition(t, time.Second, func() bool {
 		started := false
@@ -425,7 +461,7 @@ func TestBuildRunStopsExistingBinWhenBuildFailsWithStopOnError(t *testing.T) {
 		close(stopped)
 	}()
 
-	engine.buildRun()
+	engine.buildRun(nil)
 
 	select {
 	case <-stopped:
@@ -468,11 +504,73 @@ func TestBuildRunKeepsBinaryAfterFailedBuildWithStopOnError(t *testing.T) {
 	}, "initial binary process to start")
 	require.NoError(t, err)
 
-	engine.buildRun()
+	engine.buildRun(nil)
 
 	require.FileExists(t, binPath)
 }
 
+func TestBuildRunReportsFailures(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses POSIX shell commands")
+	}
+
+	tests := []struct {
+		name        string
+		preCmd      []string
+		cmd         string
+		stopOnError bool
+		want        BuildFailedMsg
+	}{
+		{
+			name:        "pre_cmd",
+			preCmd:      []string{"true", "false"},
+			cmd:         "true",
+			stopOnError: true,
+			want:        BuildFailedMsg{Error: "exit status 1", Command: "true && false", Overlay: true},
+		},
+		{
+			name: "build without stop_on_error",
+			cmd:  "exit 2",
+			want: BuildFailedMsg{Error: "exit status 2", Command: "exit 2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.Root = t.TempDir()
+			cfg.Log.Silent = true
+			cfg.Build.PreCmd = tt.preCmd
+			cfg.Build.Cmd = tt.cmd
+			cfg.Build.Bin = "true"
+			cfg.Build.StopOnError = tt.stopOnError
+			cfg.Proxy = cfgProxy{Enabled: true, ProxyPort: 1111, AppPort: 2222}
+			require.NoError(t, cfg.preprocess(nil))
+			e, err := NewEngineWithConfig(&cfg, false)
+			require.NoError(t, err)
+			t.Cleanup(func() {
+				e.stopBin()
+				_ = e.watcher.Close()
+			})
+			stream := NewProxyStream()
+			e.proxy.stream = stream
+			sub := stream.AddSubscriber()
+
+			e.buildRun(nil)
+
+			var failed []BuildFailedMsg
+			for _, msg := range sub.drain() {
+				if msg.Type == StreamMessageBuildFailed {
+					failed = append(failed, msg.Data.(BuildFailedMsg))
+				}
+			}
+			require.Len(t, failed, 1)
+			assert.Equal(t, tt.want.Command, failed[0].Command)
+			assert.Contains(t, failed[0].Error, tt.want.Error)
+			assert.Equal(t, tt.want.Overlay, failed[0].Overlay)
+		})
+	}
+}
+
 func TestShouldStopBinBeforeBuild(t *testing.T) {
 	tests := []struct {
 		goos string
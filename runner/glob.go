@@ -0,0 +1,194 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// globPattern is a doublestar glob of an include or exclude list, matched
+// against slash-separated paths relative to the root. ** stands for any
+// number of directories, {a,b} for either alternative, and a leading !
+// negates the pattern. A pattern with alternatives compiles to one
+// globPattern for each.
+type globPattern struct {
+	raw      string
+	segments []string
+	negate   bool
+}
+
+// globList is an include or exclude list. Its patterns are evaluated in
+// order and the last one matching a path decides.
+type globList []globPattern
+
+func compileGlobs(patterns []string) (globList, error) {
+	var list globList
+	for _, raw := range patterns {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		pattern, negate := strings.CutPrefix(raw, "!")
+		alternatives, err := expandBraces(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", raw, err)
+		}
+		for _, p := range alternatives {
+			p = strings.Trim(strings.TrimPrefix(p, "./"), "/")
+			if p == "" {
+				return nil, fmt.Errorf("glob %q matches nothing", raw)
+			}
+			g := globPattern{raw: raw, segments: strings.Split(p, "/"), negate: negate}
+			for _, seg := range g.segments {
+				if _, err := path.Match(seg, ""); err != nil {
+					return nil, fmt.Errorf("invalid glob %q: %w", raw, err)
+				}
+			}
+			list = append(list, g)
+		}
+	}
+	return list, nil
+}
+
+// expandBraces returns the patterns p stands for, with each {a,b} replaced
+// by each of its alternatives. Braces may nest.
+func expandBraces(p string) ([]string, error) {
+	start := strings.IndexByte(p, '{')
+	if start == -1 {
+		if strings.IndexByte(p, '}') != -1 {
+			return nil, errors.New("unmatched }")
+		}
+		return []string{p}, nil
+	}
+	var alternatives []string
+	depth, from := 0, start+1
+	for i := start; i < len(p); i++ {
+		switch p[i] {
+		case '{':
+			depth++
+		case ',':
+			if depth == 1 {
+				alternatives = append(alternatives, p[from:i])
+				from = i + 1
+			}
+		case '}':
+			if depth--; depth > 0 {
+				continue
+			}
+			alternatives = append(alternatives, p[from:i])
+			var expanded []string
+			for _, alt := range alternatives {
+				rest, err := expandBraces(p[:start] + alt + p[i+1:])
+				if err != nil {
+					return nil, err
+				}
+				expanded = append(expanded, rest...)
+			}
+			return expanded, nil
+		}
+	}
+	return nil, errors.New("unmatched {")
+}
+
+func (g globPattern) match(parts []string) bool {
+	if matchSegments(g.segments, parts) {
+		return true
+	}
+	// dir/** also matches dir itself
+	n := len(g.segments)
+	return n > 1 && g.segments[n-1] == "**" && matchSegments(g.segments[:n-1], parts)
+}
+
+// coversDir reports whether g matches everything under the dir parts: it
+// ends in ** and the rest matches the dir or one of its parents. A pattern
+// like dir/* matches the dirs right under dir, but not the files in them.
+func (g globPattern) coversDir(parts []string) bool {
+	n := len(g.segments)
+	if g.segments[n-1] != "**" {
+		return false
+	}
+	for i := range len(parts) + 1 {
+		if matchSegments(g.segments[:n-1], parts[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether the list matches rel, and returns the pattern that
+// decided it, if any.
+func (l globList) match(rel string) (string, bool) {
+	if len(l) == 0 {
+		return "", false
+	}
+	parts := strings.Split(rel, "/")
+	var (
+		by      string
+		matched bool
+	)
+	for _, g := range l {
+		if g.match(parts) {
+			by, matched = g.raw, !g.negate
+		}
+	}
+	return by, matched
+}
+
+// coversDir reports whether the list matches everything under the dir rel.
+func (l globList) coversDir(rel string) bool {
+	// a negation may re-include paths inside it
+	if l.negates() {
+		return false
+	}
+	parts := strings.Split(rel, "/")
+	for _, g := range l {
+		if g.coversDir(parts) {
+			return true
+		}
+	}
+	return false
+}
+
+// negates reports whether the list has negated patterns, which may re-include
+// paths inside a dir it matches.
+func (l globList) negates() bool {
+	for _, g := range l {
+		if g.negate {
+			return true
+		}
+	}
+	return false
+}
+
+// globPath returns path as matched by include and exclude globs.
+func (e *Engine) globPath(path string) string {
+	return filepath.ToSlash(cleanPath(e.config.rel(path)))
+}
+
+// matchIncludeGlob returns the build include pattern matching path.
+func (e *Engine) matchIncludeGlob(path string) (string, bool) {
+	return e.config.Build.include.match(e.globPath(path))
+}
+
+// matchExcludeGlob returns the build exclude pattern matching path.
+func (e *Engine) matchExcludeGlob(path string) (string, bool) {
+	return e.config.Build.exclude.match(e.globPath(path))
+}
+
+// isIncluded reports whether a file triggers a build through include_ext,
+// include_file or include. A negated include pattern overrides the other two,
+// so that e.g. "!**/*_gen.go" drops generated files include_ext matches.
+func (e *Engine) isIncluded(path string) bool {
+	if by, ok := e.matchIncludeGlob(path); by != "" {
+		return ok
+	}
+	return e.isIncludeExt(path) || e.checkIncludeFile(path)
+}
+
+// isExcludeGlobDir reports whether the exclude globs rule out everything
+// under dir, so that it need not be watched.
+func (e *Engine) isExcludeGlobDir(dir string) bool {
+	return e.config.Build.exclude.coversDir(e.globPath(dir))
+}
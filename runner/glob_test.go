@@ -0,0 +1,102 @@
+package runner
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGlobListMatch(t *testing.T) {
+	t.Parallel()
+
+	list, err := compileGlobs([]string{"internal/**/*.go", "!**/*_gen.go", "internal/api/keep_gen.go", "./cmd/*", " "})
+	require.NoError(t, err)
+	require.Len(t, list, 4)
+
+	tests := []struct {
+		path string
+		by   string
+		want bool
+	}{
+		{path: "internal/server.go", by: "internal/**/*.go", want: true},
+		{path: "internal/a/b/server.go", by: "internal/**/*.go", want: true},
+		{path: "internal/a/types_gen.go", by: "!**/*_gen.go", want: false},
+		{path: "internal/api/keep_gen.go", by: "internal/api/keep_gen.go", want: true},
+		{path: "cmd/main.go", by: "./cmd/*", want: true},
+		{path: "cmd/air/main.go"},
+		{path: "main.go"},
+	}
+	for _, tt := range tests {
+		by, ok := list.match(tt.path)
+		assert.Equal(t, tt.want, ok, tt.path)
+		assert.Equal(t, tt.by, by, tt.path)
+	}
+
+	dirs, err := compileGlobs([]string{"web/**"})
+	require.NoError(t, err)
+	_, ok := dirs.match("web")
+	assert.True(t, ok, "dir/** matches the dir itself")
+
+	braces, err := compileGlobs([]string{"**/*.{go,tmpl}", "!web/{a,b{c,d}}/*"})
+	require.NoError(t, err)
+	for path, want := range map[string]bool{
+		"main.go":          true,
+		"views/index.tmpl": true,
+		"views/index.html": false,
+		"web/a/x.go":       false,
+		"web/bd/x.go":      false,
+		"web/b/x.go":       true,
+	} {
+		_, ok := braces.match(path)
+		assert.Equal(t, want, ok, path)
+	}
+
+	for _, bad := range []string{"[a-", "!", "/", "*.{go", "*.go}"} {
+		_, err := compileGlobs([]string{bad})
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestEngineIncludeExcludeGlobs(t *testing.T) {
+	root := t.TempDir()
+	cfg := defaultConfig()
+	cfg.Root = root
+	cfg.Build.IncludeExt = []string{"go"}
+	cfg.Build.Include = []string{"config/**/*.yaml"}
+	cfg.Build.Exclude = []string{"**/node_modules/**", "**/*_gen.go"}
+	require.NoError(t, cfg.preprocess(nil))
+	e := &Engine{config: &cfg}
+	abs := func(rel string) string { return filepath.Join(cfg.Root, filepath.FromSlash(rel)) }
+
+	assert.True(t, e.isIncluded(abs("main.go")))
+	assert.True(t, e.isIncluded(abs("config/dev/app.yaml")))
+	assert.False(t, e.isIncluded(abs("app.yaml")))
+
+	by, ok := e.matchExcludeGlob(abs("api/types_gen.go"))
+	assert.True(t, ok)
+	assert.Equal(t, "**/*_gen.go", by)
+	assert.True(t, e.isExcludeGlobDir(abs("web/node_modules")))
+	assert.False(t, e.isExcludeGlobDir(abs("web")))
+
+	// a negated include pattern drops files include_ext matches
+	cfg.Build.include, _ = compileGlobs([]string{"config/**/*.yaml", "!**/*_gen.go"})
+	assert.False(t, e.isIncluded(abs("api/types_gen.go")))
+	assert.True(t, e.isIncluded(abs("api/types.go")))
+
+	// internal/* matches the dirs in internal, not the files inside them
+	cfg.Build.exclude, _ = compileGlobs([]string{"internal/*"})
+	_, ok = e.matchExcludeGlob(abs("internal/foo"))
+	assert.True(t, ok)
+	assert.False(t, e.isExcludeGlobDir(abs("internal/foo")))
+	_, ok = e.matchExcludeGlob(abs("internal/foo/bar.go"))
+	assert.False(t, ok)
+	cfg.Build.exclude, _ = compileGlobs([]string{"internal/**"})
+	assert.True(t, e.isExcludeGlobDir(abs("internal")))
+	assert.True(t, e.isExcludeGlobDir(abs("internal/foo")))
+
+	// a negation may re-include files under an excluded dir, so it is walked
+	cfg.Build.exclude, _ = compileGlobs([]string{"web/**", "!web/src/**"})
+	assert.False(t, e.isExcludeGlobDir(abs("web")))
+}
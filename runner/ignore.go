@@ -0,0 +1,264 @@
+package runner
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	gitignoreFile = ".gitignore"
+	airignoreFile = ".airignore"
+)
+
+// editorTempPatterns are the files editors write next to the ones being
+// edited. They are ignored unless an ignore file re-includes them.
+var editorTempPatterns = parseIgnorePatterns([]string{
+	"*.swp", "*.swo", "*.swx", // vim swap files
+	"*~",   // backups of vim, emacs and others
+	".#*",  // emacs lock files
+	"4913", // vim checks that it can write a dir with this file
+})
+
+// ignorePattern is one line of a gitignore file.
+type ignorePattern struct {
+	segments []string
+	negate   bool
+	dirOnly  bool
+	// anchored patterns match paths relative to the dir of their file,
+	// others match the name of a file or dir at any depth.
+	anchored bool
+}
+
+func parseIgnorePattern(line string) (ignorePattern, bool) {
+	line = strings.TrimRight(strings.TrimSuffix(line, "\r"), " ")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignorePattern{}, false
+	}
+	var p ignorePattern
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		// \# and \! escape a leading # or !
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignorePattern{}, false
+	}
+	p.anchored = strings.Contains(line, "/")
+	p.segments = strings.Split(strings.TrimPrefix(line, "/"), "/")
+	return p, true
+}
+
+func parseIgnorePatterns(lines []string) []ignorePattern {
+	var patterns []ignorePattern
+	for _, line := range lines {
+		if p, ok := parseIgnorePattern(line); ok {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// readIgnoreFile returns the patterns of the ignore file at name, or none if
+// it doesn't exist.
+func readIgnoreFile(name string) []ignorePattern {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return parseIgnorePatterns(lines)
+}
+
+// match reports whether rel, a slash-separated path relative to the dir of
+// the pattern's file, matches the pattern.
+func (p ignorePattern) match(rel string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	parts := strings.Split(rel, "/")
+	if !p.anchored {
+		return matchSegments(p.segments, parts[len(parts)-1:])
+	}
+	return matchSegments(p.segments, parts)
+}
+
+// matchSegments matches path segments against pattern segments, where **
+// stands for any number of segments.
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			pattern = pattern[1:]
+			if len(pattern) == 0 {
+				// a trailing ** matches everything inside, not the dir itself
+				return len(parts) > 0
+			}
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern, parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], parts[0]); err != nil || !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// ignoreRules decides which paths under root are ignored by .airignore
+// files, and by .gitignore files and .git/info/exclude when useGitignore is
+// set. Like git, a file in a deeper dir overrides the ones above it, and
+// nothing inside an ignored dir can be re-included.
+type ignoreRules struct {
+	root         string
+	useGitignore bool
+	// global holds the editor temp patterns and .git/info/exclude, which
+	// apply to the whole tree with the lowest precedence.
+	global []ignorePattern
+
+	mu sync.Mutex
+	// dirs caches the patterns of the ignore files of each dir, by the dir's
+	// slash-separated path relative to root.
+	dirs map[string][]ignorePattern
+}
+
+func newIgnoreRules(root string, useGitignore bool) *ignoreRules {
+	r := &ignoreRules{
+		root:         root,
+		useGitignore: useGitignore,
+		global:       editorTempPatterns,
+		dirs:         make(map[string][]ignorePattern),
+	}
+	if useGitignore {
+		r.global = slices.Concat(r.global, readIgnoreFile(filepath.Join(root, ".git", "info", "exclude")))
+	}
+	return r
+}
+
+// patterns returns the patterns of the ignore files in dir.
+func (r *ignoreRules) patterns(dir string) []ignorePattern {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if patterns, ok := r.dirs[dir]; ok {
+		return patterns
+	}
+	abs := filepath.Join(r.root, filepath.FromSlash(dir))
+	var patterns []ignorePattern
+	if r.useGitignore {
+		patterns = readIgnoreFile(filepath.Join(abs, gitignoreFile))
+	}
+	// .airignore comes last, so it can re-include what git ignores
+	patterns = append(patterns, readIgnoreFile(filepath.Join(abs, airignoreFile))...)
+	r.dirs[dir] = patterns
+	return patterns
+}
+
+// forget drops the cached patterns of dir, after one of its ignore files
+// changed.
+func (r *ignoreRules) forget(dir string) {
+	rel, ok := r.rel(dir)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.dirs, rel)
+}
+
+func (r *ignoreRules) rel(name string) (string, bool) {
+	rel, err := filepath.Rel(r.root, name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// ignored reports whether name, a file or a dir, is ignored. Paths outside
+// root are only checked against the editor temp patterns.
+func (r *ignoreRules) ignored(name string, isDir bool) bool {
+	rel, ok := r.rel(name)
+	if !ok {
+		return !isDir && isEditorTempFile(name)
+	}
+	if rel == "." {
+		return false
+	}
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if r.match(parts[:i], true) {
+			return true
+		}
+	}
+	return r.match(parts, isDir)
+}
+
+// match applies the patterns of the root and of each dir down to the parent
+// of parts; the last matching pattern decides.
+func (r *ignoreRules) match(parts []string, isDir bool) bool {
+	rel := strings.Join(parts, "/")
+	ignored := false
+	for _, p := range r.global {
+		if p.match(rel, isDir) {
+			ignored = !p.negate
+		}
+	}
+	for i := 0; i < len(parts); i++ {
+		dir := strings.Join(parts[:i], "/")
+		if dir == "" {
+			dir = "."
+		}
+		sub := strings.Join(parts[i:], "/")
+		for _, p := range r.patterns(dir) {
+			if p.match(sub, isDir) {
+				ignored = !p.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// isEditorTempFile reports whether name looks like an editor's temp file.
+func isEditorTempFile(name string) bool {
+	base := filepath.Base(name)
+	for _, p := range editorTempPatterns {
+		if p.match(base, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// isIgnored reports whether name is ignored by ignore files or is an editor
+// temp file. Rule dirs are exempt from ignore files, like from exclude_dir,
+// since the build output they watch is commonly gitignored.
+func (e *Engine) isIgnored(name string, isDir bool) bool {
+	dir := name
+	if !isDir {
+		dir = filepath.Dir(name)
+	}
+	if e.inRuleDir(dir) {
+		return !isDir && isEditorTempFile(name)
+	}
+	return e.ignore.ignored(name, isDir)
+}
@@ -0,0 +1,134 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIgnorePatternMatch(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		{pattern: "*.log", path: "app.log", want: true},
+		{pattern: "*.log", path: "logs/app.log", want: true},
+		{pattern: "*.log", path: "app.go", want: false},
+		{pattern: "node_modules/", path: "web/node_modules", isDir: true, want: true},
+		{pattern: "node_modules/", path: "web/node_modules", want: false},
+		{pattern: "/build", path: "build", isDir: true, want: true},
+		{pattern: "/build", path: "cmd/build", isDir: true, want: false},
+		{pattern: "doc/frotz", path: "doc/frotz", want: true},
+		{pattern: "doc/frotz", path: "a/doc/frotz", want: false},
+		{pattern: "**/gen", path: "gen", isDir: true, want: true},
+		{pattern: "**/gen", path: "pkg/api/gen", isDir: true, want: true},
+		{pattern: "a/**/b", path: "a/b", want: true},
+		{pattern: "a/**/b", path: "a/x/y/b", want: true},
+		{pattern: "a/**", path: "a/x/y", want: true},
+		{pattern: "a/**", path: "a", isDir: true, want: false},
+		{pattern: `\#notes`, path: "#notes", want: true},
+		{pattern: "file[0-9].txt", path: "file7.txt", want: true},
+	}
+	for _, tt := range tests {
+		p, ok := parseIgnorePattern(tt.pattern)
+		require.True(t, ok, tt.pattern)
+		assert.Equal(t, tt.want, p.match(tt.path, tt.isDir), "%s on %s", tt.pattern, tt.path)
+	}
+
+	for _, line := range []string{"", "   ", "# comment", "!", "/"} {
+		_, ok := parseIgnorePattern(line)
+		assert.False(t, ok, line)
+	}
+}
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+}
+
+func TestIgnoreRules(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		".git/info/exclude":  "secret.txt\n",
+		".gitignore":         "node_modules/\n*.log\n!keep.log\n/build\n",
+		"pkg/.gitignore":     "gen/\n!debug.log\n",
+		"pkg/.airignore":     "fixtures/\n",
+		".airignore":         "!build\n",
+		"web/.gitignore":     "!node_modules/\n",
+		"web/main.go":        "",
+		"pkg/gen/types.go":   "",
+		"pkg/debug.log":      "",
+		"node_modules/x.js":  "",
+		"pkg/fixtures/a.txt": "",
+	})
+	abs := func(rel string) string { return filepath.Join(root, filepath.FromSlash(rel)) }
+
+	tests := []struct {
+		path  string
+		isDir bool
+		git   bool
+		air   bool
+	}{
+		{path: "main.go"},
+		{path: "app.log", git: true},
+		{path: "keep.log"},
+		{path: "secret.txt", git: true},
+		{path: "node_modules", isDir: true, git: true},
+		{path: "web/node_modules", isDir: true},
+		{path: "pkg/node_modules/x.js", git: true},
+		{path: "pkg/gen", isDir: true, git: true},
+		{path: "pkg/gen/types.go", git: true},
+		{path: "pkg/debug.log"},
+		{path: "pkg/fixtures/a.txt", git: true, air: true},
+		{path: "build", isDir: true},
+		{path: "main.go.swp", git: true, air: true},
+		{path: "pkg/.#main.go", git: true, air: true},
+		{path: "pkg/4913", git: true, air: true},
+		{path: "main.go~", git: true, air: true},
+	}
+	git := newIgnoreRules(root, true)
+	air := newIgnoreRules(root, false)
+	for _, tt := range tests {
+		assert.Equal(t, tt.git, git.ignored(abs(tt.path), tt.isDir), "use_gitignore: %s", tt.path)
+		assert.Equal(t, tt.air, air.ignored(abs(tt.path), tt.isDir), ".airignore only: %s", tt.path)
+	}
+
+	assert.False(t, git.ignored(root, true))
+	assert.False(t, git.ignored(filepath.Join(filepath.Dir(root), "outside.go"), false))
+
+	// changed ignore files are read again once forgotten
+	writeFiles(t, root, map[string]string{".airignore": "!build\nmain.go\n"})
+	assert.False(t, air.ignored(abs("main.go"), false))
+	air.forget(root)
+	assert.True(t, air.ignored(abs("main.go"), false))
+}
+
+func TestEngineIsIgnoredInRuleDir(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{".gitignore": "dist/\n"})
+	cfg := defaultConfig()
+	cfg.Root = root
+	cfg.Build.Rules = []cfgRule{{Name: "assets", HotSwap: true, IncludeDir: []string{"web/dist"}}}
+	require.NoError(t, cfg.Build.normalizeRules(root))
+	e := &Engine{config: &cfg, ignore: newIgnoreRules(root, true)}
+
+	assert.True(t, e.isIgnored(filepath.Join(root, "dist"), true))
+	assert.False(t, e.isIgnored(filepath.Join(root, "web", "dist"), true))
+	assert.False(t, e.isIgnored(filepath.Join(root, "web", "dist", "app.css"), false))
+	assert.True(t, e.isIgnored(filepath.Join(root, "web", "dist", "app.css.swp"), false))
+}
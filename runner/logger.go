@@ -98,6 +98,10 @@ func (l *logger) watcher() logFunc {
 	return l.getLogger("watcher")
 }
 
+func (l *logger) proxy() logFunc {
+	return l.getLogger("proxy")
+}
+
 func rawLogger() logFunc {
 	return newLogFunc("raw", defaultConfig().Log)
 }
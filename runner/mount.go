@@ -0,0 +1,107 @@
+package runner
+
+import (
+	"bufio"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// mountPoint is a mount listed in /proc/self/mountinfo.
+type mountPoint struct {
+	path   string
+	fstype string
+}
+
+// parseMountInfo parses the mounts of a mountinfo file, see proc(5).
+func parseMountInfo(r io.Reader) []mountPoint {
+	var mounts []mountPoint
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i, field := range fields {
+			if field == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 5 || sep+1 >= len(fields) {
+			continue
+		}
+		mounts = append(mounts, mountPoint{path: unescapeMountPath(fields[4]), fstype: fields[sep+1]})
+	}
+	return mounts
+}
+
+// unescapeMountPath decodes the octal escapes of spaces, tabs, newlines and
+// backslashes in mountinfo paths.
+func unescapeMountPath(path string) string {
+	if !strings.Contains(path, `\`) {
+		return path
+	}
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+4 <= len(path) {
+			if c, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
+
+// mountOf returns the mount holding path, which is the one with the longest
+// mount path containing it.
+func mountOf(mounts []mountPoint, path string) (mountPoint, bool) {
+	var (
+		found mountPoint
+		ok    bool
+	)
+	path = filepath.Clean(path)
+	for _, m := range mounts {
+		if isSubPath(m.path, path) && (!ok || len(m.path) >= len(found.path)) {
+			found, ok = m, true
+		}
+	}
+	return found, ok
+}
+
+// isNoEventsFS reports whether file systems of fstype are known not to
+// deliver inotify events for changes made by other machines or the host of
+// a container or VM.
+func isNoEventsFS(fstype string) bool {
+	switch fstype {
+	case "9p", "virtiofs", "fuse", "nfs", "nfs4", "cifs", "smb3", "smbfs":
+		return true
+	}
+	// fuse.sshfs, fuse.grpcfuse and other FUSE file systems
+	return strings.HasPrefix(fstype, "fuse.")
+}
+
+// checkMount warns, once per mount, when dir is watched with fsnotify on a
+// file system which may not deliver events.
+func (e *Engine) checkMount(dir string) {
+	if e.isPolled(dir) {
+		return
+	}
+	m, ok := mountOf(e.mounts, dir)
+	if !ok || !isNoEventsFS(m.fstype) {
+		return
+	}
+	warn := false
+	e.withLock(func() {
+		if _, warned := e.warnedMounts[m.path]; !warned {
+			e.warnedMounts[m.path] = struct{}{}
+			warn = true
+		}
+	})
+	if warn {
+		e.watcherLog("%s is on a %s mount, which may not report changes: add it to poll_dirs", e.config.rel(dir), m.fstype)
+	}
+}
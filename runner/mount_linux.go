@@ -0,0 +1,14 @@
+package runner
+
+import "os"
+
+// readMounts returns the mounts of this process, or none if they can't be
+// read.
+func readMounts() []mountPoint {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	return parseMountInfo(f)
+}
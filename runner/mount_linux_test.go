@@ -0,0 +1,83 @@
+package runner
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testMountInfo = `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+35 22 0:31 / /proc rw,nosuid - proc proc rw
+40 22 0:40 / /workspace rw,relatime - virtiofs mount0 rw
+41 40 0:41 / /workspace/my\040app/data rw,relatime master:2 - nfs4 server:/data rw
+42 22 0:42 / /mnt/sshfs rw - fuse.sshfs me@host:/ rw
+43 22 0:43 / /mnt/disk rw - fuseblk /dev/sdb1 rw
+bogus line
+`
+
+func TestParseMountInfo(t *testing.T) {
+	t.Parallel()
+
+	mounts := parseMountInfo(strings.NewReader(testMountInfo))
+	assert.Equal(t, []mountPoint{
+		{path: "/", fstype: "ext4"},
+		{path: "/proc", fstype: "proc"},
+		{path: "/workspace", fstype: "virtiofs"},
+		{path: "/workspace/my app/data", fstype: "nfs4"},
+		{path: "/mnt/sshfs", fstype: "fuse.sshfs"},
+		{path: "/mnt/disk", fstype: "fuseblk"},
+	}, mounts)
+	assert.Equal(t, `a\b`, unescapeMountPath(`a\134b`))
+	assert.Equal(t, `a\x`, unescapeMountPath(`a\x`))
+}
+
+func TestMountOf(t *testing.T) {
+	t.Parallel()
+
+	mounts := parseMountInfo(strings.NewReader(testMountInfo))
+	tests := []struct {
+		path     string
+		fstype   string
+		noEvents bool
+	}{
+		{path: "/home/me/app", fstype: "ext4"},
+		{path: "/workspace", fstype: "virtiofs", noEvents: true},
+		{path: "/workspace/my app/cmd", fstype: "virtiofs", noEvents: true},
+		{path: "/workspace/my app/data/seed", fstype: "nfs4", noEvents: true},
+		{path: "/workspacex", fstype: "ext4"},
+		{path: "/mnt/sshfs/src", fstype: "fuse.sshfs", noEvents: true},
+		{path: "/mnt/disk/src", fstype: "fuseblk"},
+	}
+	for _, tt := range tests {
+		m, ok := mountOf(mounts, filepath.FromSlash(tt.path))
+		assert.True(t, ok, tt.path)
+		assert.Equal(t, tt.fstype, m.fstype, tt.path)
+		assert.Equal(t, tt.noEvents, isNoEventsFS(m.fstype), tt.path)
+	}
+	_, ok := mountOf(nil, "/app")
+	assert.False(t, ok)
+}
+
+func TestEngineCheckMount(t *testing.T) {
+	t.Parallel()
+
+	cfg := defaultConfig()
+	cfg.Root = "/workspace"
+	cfg.Log.Silent = true
+	cfg.Build.PollDirs = []string{"shared"}
+	cfg.Build.normalizePollDirs(cfg.Root)
+	e := &Engine{
+		config:       &cfg,
+		mounts:       parseMountInfo(strings.NewReader(testMountInfo)),
+		warnedMounts: make(map[string]struct{}),
+	}
+
+	e.checkMount("/workspace/shared")
+	assert.Empty(t, e.warnedMounts, "polled dirs are not checked")
+	e.checkMount("/workspace/cmd")
+	e.checkMount("/workspace/api")
+	e.checkMount("/home/me")
+	assert.Equal(t, map[string]struct{}{"/workspace": {}}, e.warnedMounts)
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package runner
+
+// readMounts returns no mounts: file systems are only checked on Linux.
+func readMounts() []mountPoint {
+	return nil
+}
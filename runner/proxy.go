@@ -4,13 +4,20 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/tls"
 	_ "embed"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/andybalholm/brotli"
@@ -27,11 +34,30 @@ var (
 type Streamer interface {
 	AddSubscriber() *Subscriber
 	RemoveSubscriber(id int32)
-	Reload()
+	Subscribers() int
+	Reload(msg ReloadMsg)
 	BuildFailed(msg BuildFailedMsg)
+	AssetsChanged(msg AssetsChangedMsg)
+	BuildStarted()
+	BuildSucceeded()
+	AppStarting()
+	AppReady()
+	RuntimeError(msg RuntimeErrorMsg)
 	Stop()
 }
 
+const (
+	// sseHeartbeatInterval is how often an idle event stream gets a comment,
+	// so that proxies and browsers don't time the connection out.
+	sseHeartbeatInterval = 15 * time.Second
+	// sseWriteTimeout bounds each write to an event stream, so a browser
+	// that stopped reading doesn't hold on to its subscription.
+	sseWriteTimeout = 10 * time.Second
+	// appReadyPollInterval is how often the app is dialed while waiting for
+	// it to accept connections.
+	appReadyPollInterval = 100 * time.Millisecond
+)
+
 // contentEncoding represents the type of content encoding used in HTTP responses.
 type contentEncoding int
 
@@ -43,53 +69,314 @@ const (
 
 type Proxy struct {
 	server *http.Server
-	client *http.Client
-	config *cfgProxy
-	stream Streamer
+	// client forwards to the upstreams of routes, appClient to the app.
+	client    *http.Client
+	appClient *http.Client
+	config    *cfgProxy
+	stream    Streamer
+	routes    []proxyRoute
+	// inspector is nil unless proxy.inspect is enabled
+	inspector *inspector
+	faults    *faultInjector
+	logf      logFunc
+	// heartbeat overrides sseHeartbeatInterval
+	heartbeat time.Duration
+	// built is set once a build succeeded in this session. opened guards
+	// opening the browser, open opens target in it.
+	built  atomic.Bool
+	opened sync.Once
+	open   func(target string) error
+}
+
+// proxyRoute is where requests under prefix are forwarded to. The app route
+// is the one served by the binary air builds; only it is retried while the
+// app restarts.
+type proxyRoute struct {
+	host         string
+	prefix       string
+	strip        bool
+	scheme       string
+	upstream     string
+	inject       bool
+	app          bool
+	preserveHost bool
+	hostHeader   string
 }
 
 func NewProxy(cfg *cfgProxy) *Proxy {
+	_, addr := cfg.listenAddr()
 	p := &Proxy{
 		config: cfg,
+		routes: newProxyRoutes(cfg),
 		server: &http.Server{
-			Addr: fmt.Sprintf(":%d", cfg.ProxyPort),
+			Addr: addr,
 		},
-		client: &http.Client{
-			CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
-				return http.ErrUseLastResponse
-			},
-		},
-		stream: NewProxyStream(),
+		client:    newUpstreamClient(http.DefaultTransport),
+		appClient: newUpstreamClient(newAppTransport(cfg)),
+		stream:    NewProxyStream(),
+		faults:    newFaultInjector(cfg.Faults),
+	}
+	p.open = p.startOpener
+	if cfg.Inspect {
+		p.inspector = newInspector(cfg.InspectEntries, cfg.InspectBodyLimit)
 	}
 	return p
 }
 
+func newUpstreamClient(transport http.RoundTripper) *http.Client {
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// newAppTransport returns the transport to the app, which dials its Unix
+// socket if it listens on one.
+func newAppTransport(cfg *cfgProxy) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if _, socket := cfg.appUpstream(); socket != "" {
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+	}
+	if cfg.UpstreamInsecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec // opted in for self-signed dev certificates
+	}
+	return transport
+}
+
+// newProxyRoutes returns the configured routes followed by the catch-all app
+// route, ordered so that the longest matching prefix wins.
+func newProxyRoutes(cfg *cfgProxy) []proxyRoute {
+	app, _ := cfg.appUpstream()
+	routes := make([]proxyRoute, 0, len(cfg.Routes)+1)
+	for _, r := range cfg.Routes {
+		route := proxyRoute{
+			host:         r.Host,
+			prefix:       r.Path,
+			strip:        r.StripPrefix,
+			scheme:       "http",
+			upstream:     r.Upstream,
+			inject:       r.Inject,
+			preserveHost: r.PreserveHost,
+			hostHeader:   r.HostHeader,
+		}
+		if route.upstream == "" || route.upstream == app.Host {
+			route.scheme = app.Scheme
+			route.upstream = app.Host
+			route.app = true
+		}
+		routes = append(routes, route)
+	}
+	routes = append(routes, proxyRoute{prefix: "/", scheme: app.Scheme, upstream: app.Host, inject: true, app: true})
+	// the most specific host wins first, then the longest path prefix
+	sort.SliceStable(routes, func(i, j int) bool {
+		if si, sj := hostSpecificity(routes[i].host), hostSpecificity(routes[j].host); si != sj {
+			return si > sj
+		}
+		return len(routes[i].prefix) > len(routes[j].prefix)
+	})
+	return routes
+}
+
+// hostSpecificity ranks host patterns: exact hosts before wildcards, longer
+// wildcards before shorter ones, and routes for any host last.
+func hostSpecificity(pattern string) int {
+	switch {
+	case pattern == "":
+		return 0
+	case strings.HasPrefix(pattern, "*."):
+		return len(pattern)
+	default:
+		return 1 << 16
+	}
+}
+
+// matchRoute returns the route for a request to host and path. Prefixes match
+// whole path segments, so /api matches /api and /api/users but not /apix.
+func (p *Proxy) matchRoute(host, path string) proxyRoute {
+	host = stripPort(strings.ToLower(host))
+	for _, route := range p.routes {
+		if hostMatches(host, route.host) && pathHasPrefix(path, route.prefix) {
+			return route
+		}
+	}
+	return p.routes[len(p.routes)-1]
+}
+
+// hostMatches reports whether host matches pattern. A leading "*." matches
+// one or more subdomain labels, so *.app.localhost matches
+// tenant-a.app.localhost but not app.localhost.
+func hostMatches(host, pattern string) bool {
+	if pattern == "" {
+		return true
+	}
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return host == pattern
+}
+
+func stripPort(hostport string) string {
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		return host
+	}
+	return hostport
+}
+
+func pathHasPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// upstreamURL returns the URL r is forwarded to on route.
+func (route proxyRoute) upstreamURL(r *http.Request) *url.URL {
+	u := *r.URL
+	u.Scheme = route.scheme
+	u.Host = route.upstream
+	if route.strip && route.prefix != "/" {
+		u.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(u.Path, route.prefix), "/")
+		u.RawPath = ""
+	}
+	return &u
+}
+
 func (p *Proxy) Run() {
-	http.HandleFunc("/", p.proxyHandler)
-	http.HandleFunc("/__air_internal/sse", p.reloadHandler)
-	http.HandleFunc("GET /__air_internal/worker.js", p.workerScriptHandler)
-	if err := p.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	handler := p.proxyHandler
+	if p.inspector != nil {
+		handler = p.inspector.inspect(handler)
+		http.HandleFunc("GET /__air_internal/inspect", p.accessLog(p.inspectHandler))
+		http.HandleFunc("GET /__air_internal/inspect.har", p.accessLog(p.harHandler))
+	}
+	http.HandleFunc("/", p.accessLog(handler))
+	http.HandleFunc("/__air_internal/sse", p.accessLog(p.reloadHandler))
+	http.HandleFunc("GET /__air_internal/worker.js", p.accessLog(p.workerScriptHandler))
+	if p.config.ConsoleErrors {
+		http.HandleFunc("POST /__air_internal/console", p.accessLog(p.consoleHandler))
+	}
+	http.HandleFunc("/__air_internal/faults", p.accessLog(p.faultsHandler))
+	if len(p.config.Faults) > 0 {
+		p.logFaults()
+	}
+	l, err := p.listen()
+	if err != nil {
+		log.Fatalf("failed to start the proxy server: %v", err)
+	}
+	if err := p.server.Serve(l); err != nil && err != http.ErrServerClosed {
 		log.Fatal(p.Stop())
 	}
 }
 
+func (p *Proxy) listen() (net.Listener, error) {
+	network, addr := p.config.listenAddr()
+	if network == "unix" {
+		// a socket left behind by an earlier run would make Listen fail
+		if fi, err := os.Lstat(addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(addr)
+		}
+	}
+	return net.Listen(network, addr)
+}
+
+// URL returns where the browser reaches the proxy.
+func (p *Proxy) URL() string {
+	network, addr := p.config.listenAddr()
+	if network == "unix" {
+		return "unix:" + addr
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "http://" + addr
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
 func (p *Proxy) Reload() {
-	p.stream.Reload()
+	p.stream.Reload(ReloadMsg{PreserveState: p.config.preserveState()})
 }
 
 func (p *Proxy) BuildFailed(msg BuildFailedMsg) {
 	p.stream.BuildFailed(msg)
 }
 
-func (p *Proxy) injectLiveReload(resp *http.Response) (string, bool, error) {
+func (p *Proxy) BuildStarted() {
+	p.stream.BuildStarted()
+}
+
+func (p *Proxy) BuildSucceeded() {
+	p.built.Store(true)
+	p.stream.BuildSucceeded()
+}
+
+func (p *Proxy) AppStarting() {
+	p.stream.AppStarting()
+}
+
+func (p *Proxy) RuntimeError(msg RuntimeErrorMsg) {
+	p.stream.RuntimeError(msg)
+}
+
+// WaitAppReady dials the app until it accepts connections and then reports
+// it as ready. It gives up once exit is closed, i.e. the app has exited.
+func (p *Proxy) WaitAppReady(exit <-chan struct{}) {
+	network, addr := p.appDialAddr()
+	ticker := time.NewTicker(appReadyPollInterval)
+	defer ticker.Stop()
+	for {
+		conn, err := net.DialTimeout(network, addr, appReadyPollInterval)
+		if err == nil {
+			conn.Close()
+			p.stream.AppReady()
+			p.openBrowser()
+			return
+		}
+		select {
+		case <-exit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// appDialAddr returns the network and address the app accepts connections on.
+func (p *Proxy) appDialAddr() (network, addr string) {
+	app, socket := p.config.appUpstream()
+	switch {
+	case socket != "":
+		return "unix", socket
+	case app.Port() != "":
+		return "tcp", app.Host
+	case app.Scheme == "https":
+		return "tcp", net.JoinHostPort(app.Hostname(), "443")
+	default:
+		return "tcp", net.JoinHostPort(app.Hostname(), "80")
+	}
+}
+
+// AssetsChanged asks the browser to swap the given assets in place.
+func (p *Proxy) AssetsChanged(paths []string) {
+	p.stream.AssetsChanged(AssetsChangedMsg{Paths: paths})
+}
+
+// injectLiveReload returns the page of resp with the live reload script
+// inserted before </body>, whether it decoded the body, and whether it
+// inserted the script.
+func (p *Proxy) injectLiveReload(resp *http.Response) (page string, decoded, injected bool, err error) {
 	var reader io.Reader = resp.Body
-	decoded := false
 
 	switch detectContentEncoding(resp.Header) {
 	case encodingGzip:
 		gzipReader, err := gzip.NewReader(resp.Body)
 		if err != nil {
-			return "", false, fmt.Errorf("proxy inject: failed to init gzip reader: %w", err)
+			return "", false, false, fmt.Errorf("proxy inject: failed to init gzip reader: %w", err)
 		}
 		defer gzipReader.Close()
 		reader = gzipReader
@@ -101,24 +388,50 @@ func (p *Proxy) injectLiveReload(resp *http.Response) (string, bool, error) {
 
 	buf := new(bytes.Buffer)
 	if _, err := buf.ReadFrom(reader); err != nil {
-		return "", decoded, fmt.Errorf("proxy inject: failed to read body from http response: %w", err)
+		return "", decoded, false, fmt.Errorf("proxy inject: failed to read body from http response: %w", err)
 	}
-	page := buf.String()
+	page = buf.String()
 
 	// the script will be injected before the end of the body tag. In case the tag is missing, the injection will be skipped with no error.
 	body := strings.LastIndex(page, "</body>")
 	if body == -1 {
-		return page, decoded, nil
+		return page, decoded, false, nil
 	}
 
-	script := "<script>" + ProxyScript + "</script>"
-	return page[:body] + script + page[body:], decoded, nil
+	// pages served under a Content-Security-Policy only run the script when
+	// it carries a nonce the policy allows.
+	var attrs string
+	if hasCSP(resp.Header, page) {
+		nonce, err := newCSPNonce()
+		if err != nil {
+			return "", decoded, false, fmt.Errorf("proxy inject: failed to generate csp nonce: %w", err)
+		}
+		allowLiveReloadInHeader(resp.Header, nonce)
+		page = allowLiveReloadInMeta(page, nonce)
+		body = strings.LastIndex(page, "</body>")
+		attrs += ` nonce="` + nonce + `"`
+	}
+	if p.config.ConsoleErrors {
+		attrs += " data-air-console-errors"
+	}
+
+	script := "<script" + attrs + ">" + ProxyScript + "</script>"
+	return page[:body] + script + page[body:], decoded, true, nil
 }
 
 func (p *Proxy) proxyHandler(w http.ResponseWriter, r *http.Request) {
-	appURL := r.URL
-	appURL.Scheme = "http"
-	appURL.Host = fmt.Sprintf("localhost:%d", p.config.AppPort)
+	route := p.matchRoute(r.Host, r.URL.Path)
+	appURL := route.upstreamURL(r)
+
+	w, ok := p.injectFault(w, r)
+	if !ok {
+		return
+	}
+	// static dirs don't depend on the app, they stay up while it restarts
+	if s, ok := p.matchStatic(r.URL.Path); ok {
+		p.serveStatic(w, r, s)
+		return
+	}
 
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "proxy handler: bad form", http.StatusInternalServerError)
@@ -143,36 +456,38 @@ func (p *Proxy) proxyHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	req.Header.Set("X-Forwarded-For", r.RemoteAddr)
+	req.Header.Set("X-Forwarded-Host", r.Host)
+	switch {
+	case route.preserveHost:
+		req.Host = r.Host
+	case route.hostHeader != "":
+		req.Host = route.hostHeader
+	}
 
 	// set the via header
 	viaHeaderValue := fmt.Sprintf("%s %s", r.Proto, r.Host)
 	req.Header.Set("Via", viaHeaderValue)
 
-	// air will restart the server. it may take a few seconds for it to start back up.
-	// therefore, we retry until the server becomes available or this retry loop exits with an error.
-	timeout := time.Duration(p.config.AppStartTimeout) * time.Millisecond
-	if timeout == 0 {
-		timeout = defaultProxyAppStartTimeout * time.Millisecond
-	}
-	ctx, cancel := context.WithTimeout(r.Context(), timeout)
-	defer cancel()
-
+	tr := traceFrom(r.Context())
+	upstreamStart := time.Now()
 	var resp *http.Response
-	resp, err = p.client.Do(req.WithContext(ctx))
-	for err != nil {
-		// Check if timeout has been exceeded
-		if ctx.Err() != nil {
-			err = ctx.Err()
-			break
+	if route.app {
+		ctx, cancel := context.WithTimeout(r.Context(), p.appStartTimeout())
+		defer cancel()
+		resp, err = p.doWithRetry(req.WithContext(ctx))
+		if err != nil {
+			p.appUnreachable(w, r, route)
+			return
+		}
+	} else {
+		resp, err = p.client.Do(req.WithContext(r.Context()))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("proxy handler: unable to reach upstream %s", route.upstream), http.StatusBadGateway)
+			return
 		}
-		time.Sleep(100 * time.Millisecond)
-		resp, err = p.client.Do(req.WithContext(ctx))
-	}
-	if err != nil {
-		http.Error(w, "proxy handler: unable to reach app (try increasing the proxy.app_start_timeout)", http.StatusInternalServerError)
-		return
 	}
 	defer resp.Body.Close()
+	tr.upstream = time.Since(upstreamStart)
 
 	// Copy the headers from the proxy response except Content-Length
 	for k, vv := range resp.Header {
@@ -189,8 +504,9 @@ func (p *Proxy) proxyHandler(w http.ResponseWriter, r *http.Request) {
 	// Determine if this is a streaming response
 	streaming := isStreamingResponse(resp)
 
-	// Handle non-HTML responses
-	if !strings.Contains(resp.Header.Get("Content-Type"), "text/html") {
+	// Handle non-HTML responses, and responses of routes that did not opt in
+	// to live reload
+	if !route.inject || !strings.Contains(resp.Header.Get("Content-Type"), "text/html") {
 		// Check flusher support BEFORE writing headers for streaming responses
 		var flusher http.Flusher
 		if streaming {
@@ -223,7 +539,7 @@ func (p *Proxy) proxyHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	} else {
 		// HTML: inject live reload script
-		page, decoded, err := p.injectLiveReload(resp)
+		page, decoded, injected, err := p.injectLiveReload(resp)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -231,6 +547,12 @@ func (p *Proxy) proxyHandler(w http.ResponseWriter, r *http.Request) {
 		if decoded {
 			w.Header().Del("Content-Encoding")
 		}
+		for _, name := range cspHeaders {
+			if values := resp.Header.Values(name); len(values) > 0 {
+				w.Header()[name] = values
+			}
+		}
+		tr.injected = injected
 		w.Header().Set("Content-Length", strconv.Itoa((len([]byte(page)))))
 		w.WriteHeader(resp.StatusCode)
 		if _, err := io.WriteString(w, page); err != nil {
@@ -240,6 +562,56 @@ func (p *Proxy) proxyHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// appUnreachable answers a request the app did not. Pages get the live reload
+// script, which shows why the app is down, e.g. a panic, and reloads them
+// once it is back.
+func (p *Proxy) appUnreachable(w http.ResponseWriter, r *http.Request, route proxyRoute) {
+	const msg = "proxy handler: unable to reach app (try increasing the proxy.app_start_timeout)"
+	if !route.inject || !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
+	}
+	resp := &http.Response{
+		Header: http.Header{"Content-Type": []string{"text/html; charset=utf-8"}},
+		Body:   io.NopCloser(strings.NewReader("<!DOCTYPE html><html><head><title>air</title></head><body><pre>" + msg + "</pre></body></html>")),
+	}
+	page, _, _, err := p.injectLiveReload(resp)
+	if err != nil {
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusInternalServerError)
+	_, _ = io.WriteString(w, page)
+}
+
+func (p *Proxy) appStartTimeout() time.Duration {
+	timeout := time.Duration(p.config.AppStartTimeout) * time.Millisecond
+	if timeout == 0 {
+		timeout = defaultProxyAppStartTimeout * time.Millisecond
+	}
+	return timeout
+}
+
+// doWithRetry sends req to the app. air will restart the server. it may take
+// a few seconds for it to start back up. therefore, we retry until the server
+// becomes available or the request context, bounded by the app start timeout,
+// is done.
+func (p *Proxy) doWithRetry(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	resp, err := p.appClient.Do(req)
+	for err != nil {
+		// Check if timeout has been exceeded
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		time.Sleep(100 * time.Millisecond)
+		resp, err = p.appClient.Do(req)
+	}
+	return resp, nil
+}
+
 // detectContentEncoding determines the content encoding type from HTTP headers.
 // Returns encodingNone for unsupported or multiple encodings (e.g., "gzip, br").
 func detectContentEncoding(header http.Header) contentEncoding {
@@ -274,16 +646,41 @@ func (p *Proxy) reloadHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Connection", "keep-alive")
 
 	sub := p.stream.AddSubscriber()
-	go func() {
-		<-r.Context().Done()
-		p.stream.RemoveSubscriber(sub.id)
-	}()
+	defer p.stream.RemoveSubscriber(sub.id)
+	rc := http.NewResponseController(w)
 
 	w.WriteHeader(http.StatusOK)
+	// a reconnecting browser sends the id of the last message it saw, and
+	// only needs the current status if it missed it.
+	if sub.replay != nil && r.Header.Get("Last-Event-ID") != strconv.FormatUint(sub.replay.ID, 10) {
+		fmt.Fprint(w, sub.replay.AsSSE())
+	}
 	flusher.Flush()
 
-	for msg := range sub.msgCh {
-		fmt.Fprint(w, msg.AsSSE())
+	heartbeat := p.heartbeat
+	if heartbeat == 0 {
+		heartbeat = sseHeartbeatInterval
+	}
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+	for {
+		var event string
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.done:
+			return
+		case <-sub.notify:
+			for _, msg := range sub.drain() {
+				event += msg.AsSSE()
+			}
+		case <-ticker.C:
+			event = ": heartbeat\n\n"
+		}
+		_ = rc.SetWriteDeadline(time.Now().Add(sseWriteTimeout))
+		if _, err := io.WriteString(w, event); err != nil {
+			return
+		}
 		flusher.Flush()
 	}
 }
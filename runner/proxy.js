@@ -1,4 +1,17 @@
 (() => {
+    const EVENT_TYPES = [
+        'reload', 'assets-changed',
+        'build-started', 'build-failed', 'build-succeeded', 'app-starting', 'app-ready',
+        'runtime-error',
+    ];
+    // the nonce air gave this script under a Content-Security-Policy, which
+    // the overlay's stylesheet needs too
+    const scriptNonce = document.currentScript?.nonce ?? '';
+
+    if (document.currentScript?.hasAttribute('data-air-console-errors')) {
+        forwardConsoleErrors();
+    }
+
     let worker = null;
 
     const disconnectWorker = () => {
@@ -12,17 +25,7 @@
         try {
             worker = new SharedWorker('/__air_internal/worker.js', { name: 'air-sse-worker' });
             worker.port.onmessage = (event) => {
-                const message = event.data;
-
-                switch (message.type) {
-                    case 'reload':
-                        location.reload();
-                        break;
-                    case 'build-failed':
-                        const data = parseBuildFailed(message.data);
-                        showErrorInModal(data);
-                        break;
-                }
+                handleMessage(event.data.type, event.data.data);
             };
             worker.port.start();
 
@@ -47,16 +50,338 @@
             eventSource.close();
         });
 
-        eventSource.addEventListener('reload', () => {
-            location.reload();
+        EVENT_TYPES.forEach((type) => {
+            eventSource.addEventListener(type, (event) => handleMessage(type, event.data));
+        });
+    }
+
+    function handleMessage(type, data) {
+        switch (type) {
+            case 'reload':
+                reloadPage(data);
+                break;
+            case 'assets-changed':
+                swapAssets(parsePaths(data));
+                break;
+            case 'build-started':
+                showStatus('Building…', '#b58900');
+                break;
+            case 'build-failed': {
+                const failure = parseBuildFailed(data);
+                showStatus('Build failed', '#dc322f');
+                if (failure.overlay) {
+                    showErrorInModal(failure);
+                }
+                break;
+            }
+            case 'build-succeeded':
+                hideErrorModal();
+                showStatus('Build succeeded', '#859900');
+                break;
+            case 'app-starting':
+                hideErrorModal();
+                showStatus('Starting app…', '#268bd2');
+                break;
+            case 'app-ready':
+                hideErrorModal();
+                // a page that loads while the app is up has nothing to report
+                if (statusBadge()) {
+                    showStatus('Ready', '#859900', 1500);
+                }
+                break;
+            case 'runtime-error': {
+                const err = parseRuntimeError(data);
+                showStatus(err.exited ? 'App crashed' : 'App panicked', '#dc322f');
+                showRuntimeErrorInModal(err);
+                break;
+            }
+        }
+    }
+
+    let statusTimer = null;
+
+    function statusBadge() {
+        return document.getElementById('air__status');
+    }
+
+    // showStatus shows text in a small badge in the corner of the page, and
+    // removes it after hideAfter milliseconds if given.
+    function showStatus(text, color, hideAfter) {
+        let badge = statusBadge();
+        if (!badge) {
+            badge = document.createElement('div');
+            badge.id = 'air__status';
+            badge.setAttribute('role', 'status');
+            Object.assign(badge.style, {
+                position: 'fixed',
+                right: '12px',
+                bottom: '12px',
+                zIndex: '1001',
+                padding: '4px 10px',
+                borderRadius: '12px',
+                color: 'white',
+                font: '12px/1.5 -apple-system, BlinkMacSystemFont, sans-serif',
+                boxShadow: '0 1px 4px rgba(0, 0, 0, 0.3)',
+                pointerEvents: 'none',
+            });
+            document.body.appendChild(badge);
+        }
+        badge.textContent = 'air: ' + text;
+        badge.style.backgroundColor = color;
+
+        clearTimeout(statusTimer);
+        statusTimer = null;
+        if (hideAfter) {
+            statusTimer = setTimeout(() => badge.remove(), hideAfter);
+        }
+    }
+
+    // forwardConsoleErrors sends uncaught exceptions, unhandled rejections
+    // and console.error calls to air, which prints them in the terminal.
+    function forwardConsoleErrors() {
+        let last = null;
+        let lastTime = 0;
+
+        const describe = (value) => {
+            if (value instanceof Error) {
+                return value.message;
+            }
+            if (typeof value === 'object' && value !== null) {
+                try {
+                    return JSON.stringify(value);
+                } catch (e) {
+                    // fall through to String for cyclic values
+                }
+            }
+            return String(value);
+        };
+
+        const report = (kind, message, stack, source) => {
+            // a render loop throwing on every frame shouldn't flood the terminal
+            const key = kind + message + stack;
+            if (key === last && Date.now() - lastTime < 1000) {
+                return;
+            }
+            last = key;
+            lastTime = Date.now();
+
+            fetch('/__air_internal/console', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ kind, message, stack: stack ?? '', source: source ?? '', url: location.href }),
+                keepalive: true,
+            }).catch(() => {
+                // air is restarting or gone; the error is still in the console
+            });
+        };
+
+        window.addEventListener('error', (event) => {
+            const source = event.filename ? `${event.filename}:${event.lineno}:${event.colno}` : '';
+            report('uncaught', event.message, event.error?.stack, source);
+        });
+        window.addEventListener('unhandledrejection', (event) => {
+            report('unhandledrejection', describe(event.reason), event.reason?.stack);
+        });
+
+        const consoleError = console.error;
+        console.error = function (...args) {
+            consoleError.apply(console, args);
+            const err = args.find((arg) => arg instanceof Error);
+            report('error', args.map(describe).join(' '), err?.stack);
+        };
+    }
+
+    function reloadPage(raw) {
+        let preserveState = false;
+        try {
+            preserveState = JSON.parse(raw)?.preserve_state === true;
+        } catch (e) {
+            console.warn("air: failed to parse reload payload", e);
+        }
+        if (preserveState) {
+            saveState();
+        }
+        location.reload();
+    }
+
+    const STATE_KEY = 'air:state';
+    // A snapshot older than this belongs to an earlier visit, not to the
+    // reload that just happened.
+    const STATE_TTL = 60 * 1000;
+    const SKIPPED_INPUT_TYPES = ['password', 'file', 'hidden', 'submit', 'button', 'reset', 'image'];
+
+    restoreState();
+
+    // formFields lists the fields whose values survive a reload, in document
+    // order. Restoring matches them by position, name and type, so a field
+    // added or removed by the change only resets the fields around it.
+    function formFields() {
+        return Array.from(document.querySelectorAll('input, textarea, select')).filter((el) => {
+            return !(el.tagName === 'INPUT' && SKIPPED_INPUT_TYPES.includes(el.type));
         });
+    }
 
-        eventSource.addEventListener('build-failed', (event) => {
-            const data = parseBuildFailed(event.data);
-            showErrorInModal(data);
+    function saveState() {
+        try {
+            const fields = formFields().map((el) => {
+                const field = { name: el.name || el.id, type: el.type };
+                if (el.type === 'checkbox' || el.type === 'radio') {
+                    field.checked = el.checked;
+                } else if (el.tagName === 'SELECT') {
+                    field.selected = Array.from(el.options).map((o) => o.selected);
+                } else {
+                    field.value = el.value;
+                }
+                return field;
+            });
+            const details = Array.from(document.querySelectorAll('details')).map((el) => el.open);
+            sessionStorage.setItem(STATE_KEY, JSON.stringify({
+                url: location.href,
+                time: Date.now(),
+                scrollX: window.scrollX,
+                scrollY: window.scrollY,
+                fields: fields,
+                details: details,
+            }));
+        } catch (e) {
+            console.warn("air: failed to save page state", e);
+        }
+    }
+
+    function restoreState() {
+        let state;
+        try {
+            state = JSON.parse(sessionStorage.getItem(STATE_KEY));
+            sessionStorage.removeItem(STATE_KEY);
+        } catch (e) {
+            return;
+        }
+        if (!state || state.url !== location.href || Date.now() - state.time > STATE_TTL) {
+            return;
+        }
+        if ('scrollRestoration' in history) {
+            history.scrollRestoration = 'manual';
+        }
+        const restore = () => {
+            restoreFields(state.fields ?? []);
+            document.querySelectorAll('details').forEach((el, i) => {
+                if (typeof state.details?.[i] === 'boolean') {
+                    el.open = state.details[i];
+                }
+            });
+            window.scrollTo(state.scrollX, state.scrollY);
+        };
+        if (document.readyState === 'loading') {
+            document.addEventListener('DOMContentLoaded', restore, { once: true });
+        } else {
+            restore();
+        }
+        // images and fonts loading later can shift the layout, so scroll
+        // again once everything is in place.
+        window.addEventListener('load', () => window.scrollTo(state.scrollX, state.scrollY), { once: true });
+    }
+
+    function restoreFields(fields) {
+        formFields().forEach((el, i) => {
+            const field = fields[i];
+            if (!field || field.name !== (el.name || el.id) || field.type !== el.type) {
+                return;
+            }
+            if ('checked' in field) {
+                el.checked = field.checked;
+            } else if ('selected' in field) {
+                Array.from(el.options).forEach((o, j) => {
+                    o.selected = field.selected[j] ?? o.selected;
+                });
+            } else {
+                el.value = field.value;
+            }
+            // let scripts bound to the fields catch up with the restored values
+            el.dispatchEvent(new Event('input', { bubbles: true }));
+            el.dispatchEvent(new Event('change', { bubbles: true }));
         });
     }
 
+    const STYLE_EXTS = ['css', 'scss', 'sass', 'less', 'styl', 'pcss'];
+    const IMAGE_EXTS = ['png', 'jpg', 'jpeg', 'gif', 'svg', 'webp', 'avif', 'ico'];
+
+    function parsePaths(raw) {
+        try {
+            return JSON.parse(raw).paths ?? [];
+        } catch (e) {
+            console.warn("air: failed to parse assets-changed payload", e);
+            return null;
+        }
+    }
+
+    // swapAssets re-fetches the stylesheets and images matching the changed
+    // paths by file name. Changed stylesheet sources that match no <link>
+    // (e.g. app.scss compiled to bundle.css) refresh every stylesheet. Any
+    // other change falls back to a full reload.
+    function swapAssets(paths) {
+        if (!paths) {
+            location.reload();
+            return;
+        }
+        const stamp = Date.now();
+        let refreshAllStyles = false;
+        for (const path of paths) {
+            const name = path.split('/').pop().toLowerCase();
+            const ext = name.includes('.') ? name.split('.').pop() : '';
+            if (STYLE_EXTS.includes(ext)) {
+                if (!swapStylesheets(name, stamp)) {
+                    refreshAllStyles = true;
+                }
+            } else if (IMAGE_EXTS.includes(ext)) {
+                document.querySelectorAll('img[src]').forEach((img) => {
+                    if (assetName(img.getAttribute('src')) === name) {
+                        img.src = bustCache(img.getAttribute('src'), stamp);
+                    }
+                });
+            } else {
+                location.reload();
+                return;
+            }
+        }
+        if (refreshAllStyles) {
+            swapStylesheets(null, stamp);
+        }
+    }
+
+    // swapStylesheets swaps the stylesheets named name, or all of them when
+    // name is null. The new <link> replaces the old one once it has loaded,
+    // so the page never renders unstyled.
+    function swapStylesheets(name, stamp) {
+        let swapped = false;
+        document.querySelectorAll('link[rel="stylesheet"][href]').forEach((link) => {
+            const href = link.getAttribute('href');
+            if (name !== null && assetName(href) !== name) {
+                return;
+            }
+            const next = link.cloneNode();
+            next.href = bustCache(href, stamp);
+            next.addEventListener('load', () => link.remove());
+            next.addEventListener('error', () => link.remove());
+            link.after(next);
+            swapped = true;
+        });
+        return swapped;
+    }
+
+    function assetName(url) {
+        try {
+            return new URL(url, location.href).pathname.split('/').pop().toLowerCase();
+        } catch (e) {
+            return '';
+        }
+    }
+
+    function bustCache(url, stamp) {
+        const u = new URL(url, location.href);
+        u.searchParams.set('air', stamp);
+        return u.toString();
+    }
+
     function parseBuildFailed(raw) {
         try {
             const parsed = JSON.parse(raw);
@@ -64,6 +389,7 @@
                 error: parsed.error ?? "Build failed",
                 command: parsed.command ?? "",
                 output: parsed.output ?? "",
+                overlay: parsed.overlay === true,
             };
         } catch (e) {
             console.warn("air: failed to parse build-failed payload", e);
@@ -71,81 +397,146 @@
                 error: "Build failed",
                 command: "",
                 output: String(raw),
+                overlay: true,
             };
         }
     }
 
+    function parseRuntimeError(raw) {
+        try {
+            const parsed = JSON.parse(raw);
+            return {
+                kind: parsed.kind ?? "panic",
+                message: parsed.message ?? "",
+                goroutine: parsed.goroutine ?? "",
+                frames: parsed.frames ?? [],
+                output: parsed.output ?? "",
+                exited: parsed.exited === true,
+            };
+        } catch (e) {
+            console.warn("air: failed to parse runtime-error payload", e);
+            return { kind: "panic", message: "", goroutine: "", frames: [], output: String(raw), exited: false };
+        }
+    }
+
+    function escapeHTML(text) {
+        return String(text).replace(/[&<>"']/g, (c) => `&#${c.charCodeAt(0)};`);
+    }
+
+    function hideErrorModal() {
+        const modal = document.getElementById('air__modal');
+        if (modal) {
+            modal.style.display = 'none';
+        }
+    }
+
     function showErrorInModal(data) {
-        document.body.insertAdjacentHTML(`beforeend`, `
-            <style>
-                .air__modal {
-                    display: none;
-                    position: fixed;
-                    z-index: 1000;
-                    left: 0;
-                    top: 0;
-                    width: 100%;
-                    height: 100%;
-                    background-color: rgba(0, 0, 0, 0.5);
-                    justify-content: center;
-                    align-items: center;
-                }
-                .air__modal-content {
-                    background-color: white;
-                    color: black;
-                    padding: 20px;
-                    border-radius: 5px;
-                    box-shadow: 0 2px 10px rgba(0, 0, 0, 0.1);
-                    width: 80%;
-                }
-                .air__modal-header {
-                    font-size: 1.5em;
-                    margin-bottom: 10px;
-                }
-                .air__modal-body {
-                    margin-bottom: 20px;
-                    overflow-x: auto;
-                }
-                .air__modal-close {
-                    background-color: #007bff;
-                    color: white;
-                    border: none;
-                    padding: 10px 15px;
-                    border-radius: 5px;
-                    cursor: pointer;
-                }
-                .air__modal pre {
-                    background-color: #1e1e1e;
-                    color: #f8f8f2;
-                    padding: 10px;
-                    border-radius: 5px;
-                    overflow-x: auto;
-                    white-space: pre;
-                }
-                .air__modal code {
-                    font-family: 'Courier New', Courier, monospace;
-                }
-            </style>
-            <div class="air__modal" id="air__modal">
-                <div class="air__modal-content">
-                    <div class="air__modal-header">Build Error</div>
-                    <div class="air__modal-body" id="air__modal-body"></div>
-                    <button class="air__modal-close" id="air__modal-close">Close</button>
-                </div>
-            </div>
-        `);
+        if (!document.getElementById('air__modal')) {
+            insertErrorModal();
+        }
         const modal = document.getElementById('air__modal');
         const modalBody = document.getElementById('air__modal-body');
-        const modalClose = document.getElementById('air__modal-close');
+        document.getElementById('air__modal-header').textContent = 'Build Error';
         modalBody.innerHTML = `
             <strong>Build Cmd:</strong> <pre><code>${data.command}</code></pre><br>
             <strong>Output:</strong> <pre><code>${data.output}</code></pre><br>
             <strong>Error:</strong> <pre><code>${data.error}</code></pre>
         `;
         modal.style.display = 'flex';
+    }
 
-        modalClose.addEventListener('click', () => {
-            modal.style.display = 'none';
-        });
+    // showRuntimeErrorInModal shows a panic of the app with the stack of the
+    // failing goroutine, highlighting the frames in the project's own files.
+    function showRuntimeErrorInModal(err) {
+        if (!document.getElementById('air__modal')) {
+            insertErrorModal();
+        }
+        const modal = document.getElementById('air__modal');
+        const modalBody = document.getElementById('air__modal-body');
+        document.getElementById('air__modal-header').textContent = err.exited ? 'App Crashed' : 'App Panicked';
+
+        const frames = err.frames.map((frame) => {
+            const cls = frame.project ? 'air__frame air__frame--project' : 'air__frame';
+            return `<div class="${cls}">${escapeHTML(frame.function)}\n    ${escapeHTML(frame.file)}:${frame.line}</div>`;
+        }).join('');
+        modalBody.innerHTML = `
+            <strong>${escapeHTML(err.kind)}:</strong> <pre><code>${escapeHTML(err.message)}</code></pre><br>
+            ${frames
+                ? `<strong>${escapeHTML(err.goroutine || 'Stack')}:</strong> <pre><code>${frames}</code></pre>`
+                : `<strong>Output:</strong> <pre><code>${escapeHTML(err.output)}</code></pre>`}
+        `;
+        modal.style.display = 'flex';
+    }
+
+    function insertErrorModal() {
+        const style = document.createElement('style');
+        style.nonce = scriptNonce;
+        style.textContent = `
+            .air__modal {
+                display: none;
+                position: fixed;
+                z-index: 1000;
+                left: 0;
+                top: 0;
+                width: 100%;
+                height: 100%;
+                background-color: rgba(0, 0, 0, 0.5);
+                justify-content: center;
+                align-items: center;
+            }
+            .air__modal-content {
+                background-color: white;
+                color: black;
+                padding: 20px;
+                border-radius: 5px;
+                box-shadow: 0 2px 10px rgba(0, 0, 0, 0.1);
+                width: 80%;
+            }
+            .air__modal-header {
+                font-size: 1.5em;
+                margin-bottom: 10px;
+            }
+            .air__modal-body {
+                margin-bottom: 20px;
+                overflow-x: auto;
+            }
+            .air__modal-close {
+                background-color: #007bff;
+                color: white;
+                border: none;
+                padding: 10px 15px;
+                border-radius: 5px;
+                cursor: pointer;
+            }
+            .air__modal pre {
+                background-color: #1e1e1e;
+                color: #f8f8f2;
+                padding: 10px;
+                border-radius: 5px;
+                overflow-x: auto;
+                white-space: pre;
+            }
+            .air__modal code {
+                font-family: 'Courier New', Courier, monospace;
+            }
+            .air__modal .air__frame {
+                color: #888;
+            }
+            .air__modal .air__frame--project {
+                color: #ffd866;
+                font-weight: bold;
+            }
+        `;
+        document.head.appendChild(style);
+        document.body.insertAdjacentHTML(`beforeend`, `
+            <div class="air__modal" id="air__modal">
+                <div class="air__modal-content">
+                    <div class="air__modal-header" id="air__modal-header">Build Error</div>
+                    <div class="air__modal-body" id="air__modal-body"></div>
+                    <button class="air__modal-close" id="air__modal-close">Close</button>
+                </div>
+            </div>
+        `);
+        document.getElementById('air__modal-close').addEventListener('click', hideErrorModal);
     }
 })();
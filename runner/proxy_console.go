@@ -0,0 +1,78 @@
+package runner
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+const (
+	// consoleBodyLimit caps a reported browser error, stack included.
+	consoleBodyLimit = 64 << 10
+	// consoleStackLines caps the stack frames printed for an error.
+	consoleStackLines = 20
+)
+
+// consoleReport is an error the injected script caught in the browser.
+type consoleReport struct {
+	// Kind is "error" for console.error, "uncaught" for exceptions and
+	// "unhandledrejection" for rejected promises nobody handled.
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	Stack   string `json:"stack"`
+	// Source is the script location reported with uncaught exceptions.
+	Source string `json:"source"`
+	URL    string `json:"url"`
+}
+
+func (p *Proxy) consoleHandler(w http.ResponseWriter, r *http.Request) {
+	if rejectForeignRequest(w, r, "console handler") {
+		return
+	}
+	var report consoleReport
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, consoleBodyLimit)).Decode(&report); err != nil {
+		http.Error(w, "console handler: invalid report", http.StatusBadRequest)
+		return
+	}
+	p.log("%s", formatConsoleReport(report))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func formatConsoleReport(report consoleReport) string {
+	var prefix string
+	switch report.Kind {
+	case "uncaught":
+		prefix = "Uncaught "
+	case "unhandledrejection":
+		prefix = "Unhandled rejection: "
+	}
+	message := strings.TrimSpace(report.Message)
+	if message == "" {
+		message = "(no message)"
+	}
+
+	var b strings.Builder
+	b.WriteString("[browser] " + prefix + message)
+	if report.URL != "" {
+		b.WriteString(" on " + report.URL)
+	}
+
+	// stacks usually repeat the message on their first line
+	stack := strings.Split(strings.TrimSpace(report.Stack), "\n")
+	if len(stack) > 0 && strings.Contains(message, strings.TrimSpace(stack[0])) {
+		stack = stack[1:]
+	}
+	if len(stack) == 0 && report.Source != "" {
+		stack = []string{report.Source}
+	}
+	for i, line := range stack {
+		if i == consoleStackLines {
+			b.WriteString("\n    ...")
+			break
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			b.WriteString("\n    " + line)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,107 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxy_consoleHandler(t *testing.T) {
+	var lines []string
+	proxy := &Proxy{config: &cfgProxy{ConsoleErrors: true}}
+	proxy.logf = func(format string, v ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, v...))
+	}
+
+	body := `{"kind":"uncaught","message":"TypeError: x is undefined","stack":"TypeError: x is undefined\n    at render (http://localhost:8090/app.js:3:9)","url":"http://localhost:8090/users"}`
+	post := func(header http.Header, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/__air_internal/console", strings.NewReader(body))
+		req.Header = header
+		proxy.consoleHandler(rec, req)
+		return rec
+	}
+	jsonHeader := http.Header{"Content-Type": {"application/json"}}
+	rec := post(jsonHeader, body)
+
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	require.Len(t, lines, 1)
+	assert.Equal(t, "[browser] Uncaught TypeError: x is undefined on http://localhost:8090/users\n    at render (http://localhost:8090/app.js:3:9)", lines[0])
+
+	t.Run("invalid", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, post(jsonHeader, "{").Code)
+		assert.Len(t, lines, 1)
+	})
+
+	t.Run("foreign", func(t *testing.T) {
+		// a cross-site form can post text/plain without a preflight
+		assert.Equal(t, http.StatusUnsupportedMediaType, post(http.Header{"Content-Type": {"text/plain"}}, body).Code)
+		foreign := http.Header{"Content-Type": {"application/json"}, "Origin": {"http://evil.example.com"}}
+		assert.Equal(t, http.StatusForbidden, post(foreign, body).Code)
+		assert.Len(t, lines, 1)
+	})
+}
+
+func TestFormatConsoleReport(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		report consoleReport
+		expect string
+	}{
+		{
+			name:   "console_error",
+			report: consoleReport{Kind: "error", Message: "failed to load users", URL: "http://localhost/"},
+			expect: "[browser] failed to load users on http://localhost/",
+		},
+		{
+			name:   "rejection",
+			report: consoleReport{Kind: "unhandledrejection", Message: "boom"},
+			expect: "[browser] Unhandled rejection: boom",
+		},
+		{
+			name:   "source_without_stack",
+			report: consoleReport{Kind: "uncaught", Message: "Script error.", Source: "http://localhost/app.js:1:2"},
+			expect: "[browser] Uncaught Script error.\n    http://localhost/app.js:1:2",
+		},
+		{
+			name:   "empty_message",
+			report: consoleReport{Kind: "error"},
+			expect: "[browser] (no message)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.expect, formatConsoleReport(tt.report))
+		})
+	}
+
+	t.Run("long_stack", func(t *testing.T) {
+		t.Parallel()
+		stack := strings.Repeat("at f (app.js:1:1)\n", 30)
+		got := formatConsoleReport(consoleReport{Message: "boom", Stack: stack})
+		assert.Equal(t, consoleStackLines+2, strings.Count(got, "\n")+1)
+		assert.True(t, strings.HasSuffix(got, "\n    ..."))
+	})
+}
+
+func TestProxy_injectLiveReload_ConsoleErrors(t *testing.T) {
+	proxy := NewProxy(&cfgProxy{Enabled: true, ProxyPort: 1111, AppPort: 2222, ConsoleErrors: true})
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/html"}},
+		Body:       io.NopCloser(strings.NewReader(`<body></body>`)),
+	}
+
+	got, _, _, err := proxy.injectLiveReload(resp)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(got, `<body><script data-air-console-errors>`))
+}
@@ -0,0 +1,178 @@
+package runner
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"html"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// cspHeaders lists the response headers whose policies the injected script
+// has to satisfy. Report-only policies are rewritten too, so the live reload
+// client does not flood the app's report endpoint with violations.
+var cspHeaders = []string{"Content-Security-Policy", "Content-Security-Policy-Report-Only"}
+
+var (
+	cspMetaRe        = regexp.MustCompile(`(?is)<meta\s[^>]*http-equiv\s*=\s*["']?content-security-policy(?:-report-only)?["']?[^>]*>`)
+	cspMetaContentRe = regexp.MustCompile(`(?is)(\scontent\s*=\s*)("[^"]*"|'[^']*')`)
+)
+
+// newCSPNonce returns a random nonce suitable for a script-src or style-src
+// source.
+func newCSPNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// hasCSP reports whether the response carries a policy in its headers or in
+// a <meta http-equiv> tag of the page.
+func hasCSP(header http.Header, page string) bool {
+	for _, name := range cspHeaders {
+		if header.Get(name) != "" {
+			return true
+		}
+	}
+	return cspMetaRe.MatchString(page)
+}
+
+// allowLiveReloadInHeader rewrites every policy in header in place.
+func allowLiveReloadInHeader(header http.Header, nonce string) {
+	for _, name := range cspHeaders {
+		values := header.Values(name)
+		for i, v := range values {
+			values[i] = allowLiveReload(v, nonce)
+		}
+	}
+}
+
+// allowLiveReloadInMeta rewrites the policies of the <meta http-equiv> tags
+// in page.
+func allowLiveReloadInMeta(page, nonce string) string {
+	return cspMetaRe.ReplaceAllStringFunc(page, func(tag string) string {
+		return cspMetaContentRe.ReplaceAllStringFunc(tag, func(attr string) string {
+			m := cspMetaContentRe.FindStringSubmatch(attr)
+			quoted := m[2]
+			policy := html.UnescapeString(quoted[1 : len(quoted)-1])
+			return m[1] + `"` + html.EscapeString(allowLiveReload(policy, nonce)) + `"`
+		})
+	})
+}
+
+// allowLiveReload returns policy amended so that the injected script carrying
+// nonce may run and style its error overlay, and so that it may open the SSE
+// connection and start the shared worker under /__air_internal/. A header may hold several
+// comma-separated policies; each one is enforced, so each one is amended.
+func allowLiveReload(policy, nonce string) string {
+	policies := strings.Split(policy, ",")
+	for i, p := range policies {
+		policies[i] = allowLiveReloadInPolicy(p, nonce)
+	}
+	return strings.Join(policies, ", ")
+}
+
+type cspDirective struct {
+	name    string
+	sources []string
+}
+
+func allowLiveReloadInPolicy(policy, nonce string) string {
+	var directives []*cspDirective
+	byName := make(map[string]*cspDirective)
+	for _, raw := range strings.Split(policy, ";") {
+		fields := strings.Fields(raw)
+		if len(fields) == 0 {
+			continue
+		}
+		d := &cspDirective{name: strings.ToLower(fields[0]), sources: fields[1:]}
+		if _, dup := byName[d.name]; dup {
+			// browsers ignore repeated directives
+			continue
+		}
+		directives = append(directives, d)
+		byName[d.name] = d
+	}
+	if len(directives) == 0 {
+		return policy
+	}
+
+	// derive adds a directive that starts from its fallback's sources, so
+	// adding our source does not loosen or tighten anything else.
+	derive := func(name string, fallbacks ...string) *cspDirective {
+		if d, ok := byName[name]; ok {
+			return d
+		}
+		for _, fb := range fallbacks {
+			if d, ok := byName[fb]; ok {
+				nd := &cspDirective{name: name, sources: append([]string(nil), d.sources...)}
+				directives = append(directives, nd)
+				byName[name] = nd
+				return nd
+			}
+		}
+		return nil
+	}
+
+	// worker-src has to be derived before script-src gains the nonce, since
+	// nonces do not apply to workers.
+	if d := derive("worker-src", "child-src", "script-src", "default-src"); d != nil {
+		d.add("'self'")
+	}
+	if d := derive("connect-src", "default-src"); d != nil {
+		d.add("'self'")
+	}
+	// the overlay's stylesheet carries the nonce too
+	for _, name := range []string{"script-src", "script-src-elem", "style-src", "style-src-elem"} {
+		var d *cspDirective
+		if strings.HasSuffix(name, "-elem") {
+			d = byName[name]
+		} else {
+			d = derive(name, "default-src")
+		}
+		if d == nil || d.allowsInline() {
+			continue
+		}
+		d.add("'nonce-" + nonce + "'")
+	}
+
+	parts := make([]string, len(directives))
+	for i, d := range directives {
+		parts[i] = strings.Join(append([]string{d.name}, d.sources...), " ")
+	}
+	return strings.Join(parts, "; ")
+}
+
+// add appends source unless it is already allowed. A 'none' source list is
+// replaced, since 'none' combined with anything else is invalid.
+func (d *cspDirective) add(source string) {
+	for _, s := range d.sources {
+		if strings.EqualFold(s, source) {
+			return
+		}
+	}
+	if len(d.sources) == 1 && strings.EqualFold(d.sources[0], "'none'") {
+		d.sources = nil
+	}
+	d.sources = append(d.sources, source)
+}
+
+// allowsInline reports whether inline scripts or styles are already allowed
+// by d. Adding a nonce there would make browsers ignore 'unsafe-inline' and
+// break the app's own inline scripts or styles.
+func (d *cspDirective) allowsInline() bool {
+	inline := false
+	for _, s := range d.sources {
+		ls := strings.ToLower(s)
+		if strings.HasPrefix(ls, "'nonce-") || strings.HasPrefix(ls, "'sha") || ls == "'strict-dynamic'" {
+			return false
+		}
+		if ls == "'unsafe-inline'" {
+			inline = true
+		}
+	}
+	return inline
+}
@@ -0,0 +1,118 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllowLiveReload(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		policy string
+		expect string
+	}{
+		{
+			name:   "script_src_gets_nonce",
+			policy: "script-src 'self'; connect-src 'self'; worker-src 'self'",
+			expect: "script-src 'self' 'nonce-abc'; connect-src 'self'; worker-src 'self'",
+		},
+		{
+			name:   "default_src_is_split",
+			policy: "default-src 'self' https://cdn.example.com",
+			expect: "default-src 'self' https://cdn.example.com; worker-src 'self' https://cdn.example.com; connect-src 'self' https://cdn.example.com; script-src 'self' https://cdn.example.com 'nonce-abc'; style-src 'self' https://cdn.example.com 'nonce-abc'",
+		},
+		{
+			name:   "none_is_replaced",
+			policy: "default-src 'none'",
+			expect: "default-src 'none'; worker-src 'self'; connect-src 'self'; script-src 'nonce-abc'; style-src 'nonce-abc'",
+		},
+		{
+			name:   "unsafe_inline_is_left_alone",
+			policy: "script-src 'self' 'unsafe-inline'",
+			expect: "script-src 'self' 'unsafe-inline'; worker-src 'self' 'unsafe-inline'",
+		},
+		{
+			name:   "strict_dynamic_gets_nonce",
+			policy: "script-src 'strict-dynamic' 'nonce-app'",
+			expect: "script-src 'strict-dynamic' 'nonce-app' 'nonce-abc'; worker-src 'strict-dynamic' 'nonce-app' 'self'",
+		},
+		{
+			name:   "style_src_gets_nonce",
+			policy: "script-src 'self'; style-src 'self'; style-src-elem 'self' https://fonts.example.com",
+			expect: "script-src 'self' 'nonce-abc'; style-src 'self' 'nonce-abc'; style-src-elem 'self' https://fonts.example.com 'nonce-abc'; worker-src 'self'",
+		},
+		{
+			name:   "inline_styles_left_alone",
+			policy: "default-src 'self'; style-src 'self' 'unsafe-inline'",
+			expect: "default-src 'self'; style-src 'self' 'unsafe-inline'; worker-src 'self'; connect-src 'self'; script-src 'self' 'nonce-abc'",
+		},
+		{
+			name:   "unrelated_policy_untouched",
+			policy: "frame-ancestors 'none'",
+			expect: "frame-ancestors 'none'",
+		},
+		{
+			name:   "multiple_policies",
+			policy: "script-src 'self', connect-src 'none'",
+			expect: "script-src 'self' 'nonce-abc'; worker-src 'self', connect-src 'self'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.expect, allowLiveReload(tt.policy, "abc"))
+		})
+	}
+}
+
+func TestAllowLiveReloadInMeta(t *testing.T) {
+	t.Parallel()
+
+	page := `<head><meta http-equiv="Content-Security-Policy" content="script-src 'self'"></head>`
+	got := allowLiveReloadInMeta(page, "abc")
+	assert.Equal(t, `<head><meta http-equiv="Content-Security-Policy" content="script-src &#39;self&#39; &#39;nonce-abc&#39;; worker-src &#39;self&#39;"></head>`, got)
+}
+
+func TestProxy_proxyHandler_CSP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'")
+		w.Header().Set("Content-Security-Policy-Report-Only", "script-src 'none'")
+		fmt.Fprint(w, "<body><h1>csp</h1></body>")
+	}))
+	defer srv.Close()
+
+	proxy := NewProxy(&cfgProxy{
+		Enabled:   true,
+		ProxyPort: proxyPort,
+		AppPort:   getServerPort(t, srv),
+	})
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("http://localhost:%d/", proxyPort), nil)
+	rec := httptest.NewRecorder()
+	proxy.proxyHandler(rec, req)
+
+	resp := rec.Result()
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	m := regexp.MustCompile(`<script nonce="([^"]+)">`).FindStringSubmatch(string(body))
+	require.Len(t, m, 2, "script should carry a nonce")
+	nonce := m[1]
+	assert.Contains(t, resp.Header.Get("Content-Security-Policy"), "'nonce-"+nonce+"'")
+	assert.Contains(t, resp.Header.Get("Content-Security-Policy-Report-Only"), "'nonce-"+nonce+"'")
+	assert.Contains(t, resp.Header.Get("Content-Security-Policy"), "connect-src 'self'")
+	// the overlay's stylesheet is allowed with the script's nonce
+	assert.Contains(t, resp.Header.Get("Content-Security-Policy"), "style-src 'self' 'nonce-"+nonce+"'")
+	assert.Contains(t, ProxyScript, "style.nonce = scriptNonce")
+}
@@ -0,0 +1,241 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// faultInjector holds the faults currently applied by proxyHandler. They
+// start out as configured and can be changed at runtime.
+type faultInjector struct {
+	mu     sync.RWMutex
+	faults []cfgProxyFault
+	// rand returns a number in [0, 1) deciding whether errors and resets
+	// are injected.
+	rand func() float64
+}
+
+func newFaultInjector(faults []cfgProxyFault) *faultInjector {
+	return &faultInjector{faults: slices.Clone(faults), rand: rand.Float64}
+}
+
+// match returns the fault with the longest path prefix matching urlPath.
+func (f *faultInjector) match(urlPath string) (cfgProxyFault, bool) {
+	if f == nil {
+		return cfgProxyFault{}, false
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	var (
+		best  cfgProxyFault
+		found bool
+	)
+	for _, fault := range f.faults {
+		if pathHasPrefix(urlPath, fault.Path) && (!found || len(fault.Path) > len(best.Path)) {
+			best, found = fault, true
+		}
+	}
+	return best, found
+}
+
+func (f *faultInjector) list() []cfgProxyFault {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return append([]cfgProxyFault{}, f.faults...)
+}
+
+// set adds fault, replacing the one for the same path if any.
+func (f *faultInjector) set(fault cfgProxyFault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = slices.DeleteFunc(f.faults, func(c cfgProxyFault) bool { return c.Path == fault.Path })
+	f.faults = append(f.faults, fault)
+}
+
+// remove removes the fault for path, or all of them when path is empty.
+func (f *faultInjector) remove(path string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if path == "" {
+		f.faults = nil
+		return
+	}
+	f.faults = slices.DeleteFunc(f.faults, func(c cfgProxyFault) bool { return c.Path == path })
+}
+
+func (f cfgProxyFault) String() string {
+	parts := []string{f.Path}
+	if f.Latency > 0 {
+		parts = append(parts, fmt.Sprintf("latency=%dms", f.Latency))
+	}
+	if f.Bandwidth > 0 {
+		parts = append(parts, fmt.Sprintf("bandwidth=%s/s", formatBytes(int64(f.Bandwidth))))
+	}
+	if f.ErrorRate > 0 {
+		parts = append(parts, fmt.Sprintf("error_rate=%g(%d)", f.ErrorRate, f.ErrorStatus))
+	}
+	if f.ResetRate > 0 {
+		parts = append(parts, fmt.Sprintf("reset_rate=%g", f.ResetRate))
+	}
+	return strings.Join(parts, " ")
+}
+
+// logFaults prints the active faults, so that slow or failing requests are
+// not mistaken for bugs in the app.
+func (p *Proxy) logFaults() {
+	faults := p.faults.list()
+	if len(faults) == 0 {
+		p.log("[fault] no faults active")
+		return
+	}
+	for _, fault := range faults {
+		p.log("[fault] active: %s", fault)
+	}
+}
+
+// injectFault applies the fault matching r, if any. It returns the writer to
+// forward the response to, and false if the request has been answered.
+func (p *Proxy) injectFault(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, bool) {
+	fault, ok := p.faults.match(r.URL.Path)
+	if !ok {
+		return w, true
+	}
+	if fault.ResetRate > 0 && p.faults.rand() < fault.ResetRate {
+		p.log("[fault] %s %s: connection reset", r.Method, r.URL.RequestURI())
+		resetConnection(w)
+		return w, false
+	}
+	if fault.ErrorRate > 0 && p.faults.rand() < fault.ErrorRate {
+		p.log("[fault] %s %s: %d", r.Method, r.URL.RequestURI(), fault.ErrorStatus)
+		http.Error(w, "air: injected fault", fault.ErrorStatus)
+		return w, false
+	}
+	if fault.Latency > 0 {
+		p.log("[fault] %s %s: +%dms", r.Method, r.URL.RequestURI(), fault.Latency)
+		select {
+		case <-time.After(time.Duration(fault.Latency) * time.Millisecond):
+		case <-r.Context().Done():
+			return w, false
+		}
+	}
+	if fault.Bandwidth > 0 {
+		return &throttledWriter{ResponseWriter: w, bytesPerSec: fault.Bandwidth}, true
+	}
+	return w, true
+}
+
+// resetConnection drops the client connection without a response. TCP
+// connections are reset rather than closed gracefully.
+func resetConnection(w http.ResponseWriter) {
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		// e.g. HTTP/2; aborting the handler drops the stream instead
+		panic(http.ErrAbortHandler)
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		_ = tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// throttledWriter writes the response body at about bytesPerSec.
+type throttledWriter struct {
+	http.ResponseWriter
+	bytesPerSec int
+}
+
+// throttleTick is how often a throttled response sends a chunk.
+const throttleTick = 100 * time.Millisecond
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	chunk := max(w.bytesPerSec*int(throttleTick)/int(time.Second), 1)
+	written := 0
+	for len(p) > 0 {
+		n := min(chunk, len(p))
+		m, err := w.ResponseWriter.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		w.Flush()
+		p = p[n:]
+		time.Sleep(time.Duration(n) * time.Second / time.Duration(w.bytesPerSec))
+	}
+	return written, nil
+}
+
+func (w *throttledWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// faultsHandler lists the active faults on GET, sets the fault for a path on
+// POST, and removes the fault for ?path=, or all of them, on DELETE.
+func (p *Proxy) faultsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if rejectForeignRequest(w, r, "faults handler") {
+			return
+		}
+		var fault cfgProxyFault
+		if err := json.NewDecoder(r.Body).Decode(&fault); err != nil {
+			http.Error(w, "faults handler: invalid fault: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := fault.normalize(); err != nil {
+			http.Error(w, "faults handler: invalid fault: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		p.faults.set(fault)
+		p.logFaults()
+	case http.MethodDelete:
+		if rejectForeignRequest(w, r, "faults handler") {
+			return
+		}
+		p.faults.remove(r.URL.Query().Get("path"))
+		p.logFaults()
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "faults handler: method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(p.faults.list())
+}
+
+// rejectForeignRequest answers with an error, and returns true, unless r comes
+// from the proxy's own pages or from a client sending no Origin, like curl,
+// and its body, if any, is JSON. Other sites can't send JSON, or methods like
+// DELETE, without a CORS preflight, which the internal handlers don't answer.
+func rejectForeignRequest(w http.ResponseWriter, r *http.Request, handler string) bool {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host != r.Host {
+			http.Error(w, handler+": foreign origin "+origin, http.StatusForbidden)
+			return true
+		}
+	}
+	if r.ContentLength == 0 {
+		return false
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		http.Error(w, handler+": Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return true
+	}
+	return false
+}
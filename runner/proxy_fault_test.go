@@ -0,0 +1,184 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultInjectorMatch(t *testing.T) {
+	t.Parallel()
+
+	f := newFaultInjector([]cfgProxyFault{
+		{Path: "/", Latency: 1},
+		{Path: "/api", Latency: 2},
+		{Path: "/api/slow", Latency: 3},
+	})
+
+	tests := []struct {
+		path    string
+		latency int
+	}{
+		{path: "/", latency: 1},
+		{path: "/apis", latency: 1},
+		{path: "/api/users", latency: 2},
+		{path: "/api/slow/report", latency: 3},
+	}
+	for _, tt := range tests {
+		fault, ok := f.match(tt.path)
+		require.True(t, ok, tt.path)
+		assert.Equal(t, tt.latency, fault.Latency, tt.path)
+	}
+
+	var none *faultInjector
+	_, ok := none.match("/")
+	assert.False(t, ok)
+}
+
+// newFaultProxy returns a proxy in front of an app counting its requests.
+func newFaultProxy(t *testing.T, faults ...cfgProxyFault) (*Proxy, *atomic.Int32) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		hits.Add(1)
+		fmt.Fprint(w, strings.Repeat("a", 400))
+	}))
+	t.Cleanup(srv.Close)
+
+	proxy := NewProxy(&cfgProxy{Enabled: true, ProxyPort: proxyPort, AppPort: getServerPort(t, srv), Faults: faults})
+	proxy.faults.rand = func() float64 { return 0 }
+	return proxy, &hits
+}
+
+func TestProxy_proxyHandler_Faults(t *testing.T) {
+	t.Run("error", func(t *testing.T) {
+		proxy, hits := newFaultProxy(t, cfgProxyFault{Path: "/api", ErrorRate: 0.5, ErrorStatus: 502})
+		rec := httptest.NewRecorder()
+		proxy.proxyHandler(rec, httptest.NewRequest("GET", "/api/users", nil))
+
+		assert.Equal(t, http.StatusBadGateway, rec.Code)
+		assert.Equal(t, int32(0), hits.Load())
+	})
+
+	t.Run("error_rate_not_hit", func(t *testing.T) {
+		proxy, hits := newFaultProxy(t, cfgProxyFault{Path: "/api", ErrorRate: 0.5, ErrorStatus: 502})
+		proxy.faults.rand = func() float64 { return 0.9 }
+		rec := httptest.NewRecorder()
+		proxy.proxyHandler(rec, httptest.NewRequest("GET", "/api/users", nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, int32(1), hits.Load())
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		proxy, _ := newFaultProxy(t, cfgProxyFault{Path: "/", Latency: 50})
+		start := time.Now()
+		rec := httptest.NewRecorder()
+		proxy.proxyHandler(rec, httptest.NewRequest("GET", "/", nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	})
+
+	t.Run("bandwidth", func(t *testing.T) {
+		proxy, _ := newFaultProxy(t, cfgProxyFault{Path: "/", Bandwidth: 2000})
+		start := time.Now()
+		rec := httptest.NewRecorder()
+		proxy.proxyHandler(rec, httptest.NewRequest("GET", "/", nil))
+
+		assert.Equal(t, 400, rec.Body.Len())
+		// 400 bytes at 2000 bytes per second
+		assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		proxy, hits := newFaultProxy(t, cfgProxyFault{Path: "/", ResetRate: 1})
+		srv := httptest.NewServer(http.HandlerFunc(proxy.proxyHandler))
+		defer srv.Close()
+
+		resp, err := http.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		require.Error(t, err)
+		assert.Equal(t, int32(0), hits.Load())
+	})
+}
+
+func TestProxy_faultsHandler(t *testing.T) {
+	var lines []string
+	proxy := NewProxy(&cfgProxy{Enabled: true, ProxyPort: proxyPort, AppPort: 2222})
+	proxy.logf = func(format string, v ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, v...))
+	}
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		proxy.faultsHandler(rec, req)
+		return rec
+	}
+	list := func(rec *httptest.ResponseRecorder) []cfgProxyFault {
+		var faults []cfgProxyFault
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &faults))
+		return faults
+	}
+
+	assert.Empty(t, list(do("GET", "/__air_internal/faults", "")))
+
+	rec := do("POST", "/__air_internal/faults", `{"path":"/api","latency":2000,"error_rate":0.1}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []cfgProxyFault{{Path: "/api", Latency: 2000, ErrorRate: 0.1, ErrorStatus: 503}}, list(rec))
+	assert.Equal(t, []string{"[fault] active: /api latency=2000ms error_rate=0.1(503)"}, lines)
+
+	// posting the same path replaces its fault
+	do("POST", "/__air_internal/faults", `{"path":"/api","latency":100}`)
+	do("POST", "/__air_internal/faults", `{"path":"/ws","reset_rate":1}`)
+	assert.Equal(t, []cfgProxyFault{
+		{Path: "/api", Latency: 100, ErrorStatus: 503},
+		{Path: "/ws", ResetRate: 1, ErrorStatus: 503},
+	}, list(do("GET", "/__air_internal/faults", "")))
+
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/__air_internal/faults", `{"path":"/api","error_rate":2}`).Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, do("PUT", "/__air_internal/faults", "").Code)
+
+	// other sites can't change the faults
+	for _, header := range []http.Header{
+		{"Content-Type": {"text/plain"}},
+		{"Content-Type": {"application/json"}, "Origin": {"http://evil.example.com"}},
+	} {
+		for _, method := range []string{"POST", "DELETE"} {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(method, "/__air_internal/faults", strings.NewReader(`{"path":"/"}`))
+			req.Header = header
+			proxy.faultsHandler(rec, req)
+			assert.Contains(t, []int{http.StatusForbidden, http.StatusUnsupportedMediaType}, rec.Code, method, header)
+		}
+	}
+	req := httptest.NewRequest("POST", "/__air_internal/faults", strings.NewReader(`{"path":"/"}`))
+	req.Header = http.Header{"Content-Type": {"application/json; charset=utf-8"}, "Origin": {"http://" + req.Host}}
+	rec = httptest.NewRecorder()
+	proxy.faultsHandler(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Len(t, list(do("DELETE", "/__air_internal/faults?path=/", "")), 2)
+
+	// a DELETE without a body needs no Content-Type
+	rec = httptest.NewRecorder()
+	proxy.faultsHandler(rec, httptest.NewRequest("DELETE", "/__air_internal/faults?path=/ws", nil))
+	assert.Len(t, list(rec), 1)
+	req = httptest.NewRequest("DELETE", "/__air_internal/faults", nil)
+	req.Header.Set("Origin", "http://evil.example.com")
+	rec = httptest.NewRecorder()
+	proxy.faultsHandler(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Empty(t, list(do("DELETE", "/__air_internal/faults", "")))
+	assert.Equal(t, "[fault] no faults active", lines[len(lines)-1])
+}
@@ -0,0 +1,444 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// inspectEntry is one request/response pair recorded by the inspector.
+type inspectEntry struct {
+	Started        time.Time
+	Duration       time.Duration
+	Method         string
+	URL            string
+	Proto          string
+	Status         int
+	RequestHeader  http.Header
+	ResponseHeader http.Header
+	RequestBody    capturedBody
+	ResponseBody   capturedBody
+}
+
+// capturedBody keeps up to limit bytes of a body and counts the rest.
+type capturedBody struct {
+	Data      []byte
+	Size      int64
+	Truncated bool
+	limit     int
+}
+
+func (b *capturedBody) Write(p []byte) (int, error) {
+	b.Size += int64(len(p))
+	if room := b.limit - len(b.Data); room > 0 {
+		if len(p) > room {
+			b.Data = append(b.Data, p[:room]...)
+			b.Truncated = true
+		} else {
+			b.Data = append(b.Data, p...)
+		}
+	} else if len(p) > 0 {
+		b.Truncated = true
+	}
+	return len(p), nil
+}
+
+// inspector keeps the most recent proxied requests in a ring buffer.
+type inspector struct {
+	mu        sync.Mutex
+	entries   []inspectEntry
+	next      int
+	full      bool
+	bodyLimit int
+}
+
+func newInspector(size, bodyLimit int) *inspector {
+	if size <= 0 {
+		size = defaultProxyInspectEntries
+	}
+	if bodyLimit <= 0 {
+		bodyLimit = defaultProxyInspectBodyLimit
+	}
+	return &inspector{entries: make([]inspectEntry, size), bodyLimit: bodyLimit}
+}
+
+func (in *inspector) add(entry inspectEntry) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.entries[in.next] = entry
+	in.next = (in.next + 1) % len(in.entries)
+	if in.next == 0 {
+		in.full = true
+	}
+}
+
+// list returns the recorded entries, oldest first.
+func (in *inspector) list() []inspectEntry {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if !in.full {
+		return append([]inspectEntry(nil), in.entries[:in.next]...)
+	}
+	out := make([]inspectEntry, 0, len(in.entries))
+	out = append(out, in.entries[in.next:]...)
+	return append(out, in.entries[:in.next]...)
+}
+
+// recordingWriter remembers the status and the body written through it.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   capturedBody
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	_, _ = w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Flush keeps streaming responses working through the recorder.
+func (w *recordingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// inspectedBody is a request body whose first bytes were captured before it
+// was forwarded. The transport may still be sending it once the response is
+// recorded, so the rest is only counted, as far as it was sent by then.
+type inspectedBody struct {
+	io.Reader
+	io.Closer
+	rest atomic.Int64
+}
+
+// readInspectedBody captures up to limit bytes of r's body into captured, and
+// replaces the body with one sending them along with the rest. It reads a byte
+// more to tell whether the capture is truncated.
+func readInspectedBody(r *http.Request, captured *capturedBody, limit int) *inspectedBody {
+	head, _ := io.ReadAll(io.LimitReader(r.Body, int64(limit)+1))
+	_, _ = captured.Write(head)
+	body := &inspectedBody{Closer: r.Body}
+	body.Reader = io.MultiReader(bytes.NewReader(head), countingReader{r.Body, &body.rest})
+	r.Body = body
+	return body
+}
+
+type countingReader struct {
+	io.Reader
+	n *atomic.Int64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// inspect wraps next so that every request it serves is recorded.
+func (in *inspector) inspect(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry := inspectEntry{
+			Started:       time.Now(),
+			Method:        r.Method,
+			URL:           requestURL(r),
+			Proto:         r.Proto,
+			RequestHeader: r.Header.Clone(),
+			RequestBody:   capturedBody{limit: in.bodyLimit},
+		}
+		var body *inspectedBody
+		if r.Body != nil && r.Body != http.NoBody {
+			body = readInspectedBody(r, &entry.RequestBody, in.bodyLimit)
+		}
+		rw := &recordingWriter{ResponseWriter: w, body: capturedBody{limit: in.bodyLimit}}
+
+		next(rw, r)
+
+		entry.Duration = time.Since(entry.Started)
+		entry.Status = rw.status
+		entry.ResponseHeader = w.Header().Clone()
+		entry.ResponseBody = rw.body
+		if body != nil {
+			if rest := body.rest.Load(); rest > 0 {
+				entry.RequestBody.Size += rest
+				entry.RequestBody.Truncated = true
+			}
+		}
+		in.add(entry)
+	}
+}
+
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+var inspectTemplate = template.Must(template.New("inspect").Funcs(template.FuncMap{
+	"ms":   func(d time.Duration) string { return d.Round(time.Microsecond).String() },
+	"body": func(b capturedBody) string { return harText(b.Data) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>air inspector</title>
+<style>
+body { font-family: sans-serif; margin: 20px; }
+table { border-collapse: collapse; width: 100%; }
+td, th { text-align: left; padding: 4px 8px; border-bottom: 1px solid #ddd; vertical-align: top; }
+pre { background: #1e1e1e; color: #f8f8f2; padding: 10px; overflow-x: auto; max-height: 300px; }
+.err { color: #c00; }
+</style>
+</head>
+<body>
+<h1>air inspector</h1>
+<p>{{len .}} recent requests, newest first. <a href="/__air_internal/inspect.har">Export HAR</a></p>
+<table>
+<tr><th>Time</th><th>Method</th><th>URL</th><th>Status</th><th>Duration</th><th>Size</th></tr>
+{{range .}}
+<tr>
+<td>{{.Started.Format "15:04:05.000"}}</td>
+<td>{{.Method}}</td>
+<td><details><summary>{{.URL}}</summary>
+<h4>Request headers</h4><pre>{{range $k, $v := .RequestHeader}}{{$k}}: {{range $v}}{{.}} {{end}}
+{{end}}</pre>
+{{if .RequestBody.Size}}<h4>Request body ({{.RequestBody.Size}} bytes{{if .RequestBody.Truncated}}, truncated{{end}})</h4><pre>{{body .RequestBody}}</pre>{{end}}
+<h4>Response headers</h4><pre>{{range $k, $v := .ResponseHeader}}{{$k}}: {{range $v}}{{.}} {{end}}
+{{end}}</pre>
+{{if .ResponseBody.Size}}<h4>Response body ({{.ResponseBody.Size}} bytes{{if .ResponseBody.Truncated}}, truncated{{end}})</h4><pre>{{body .ResponseBody}}</pre>{{end}}
+</details></td>
+<td{{if ge .Status 400}} class="err"{{end}}>{{.Status}}</td>
+<td>{{ms .Duration}}</td>
+<td>{{.ResponseBody.Size}}</td>
+</tr>
+{{end}}
+</table>
+</body>
+</html>
+`))
+
+func (p *Proxy) inspectHandler(w http.ResponseWriter, _ *http.Request) {
+	entries := p.inspector.list()
+	// newest first
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := inspectTemplate.Execute(w, entries); err != nil {
+		http.Error(w, "inspect handler: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (p *Proxy) harHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="air.har"`)
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(newHAR(p.inspector.list())); err != nil {
+		http.Error(w, "har handler: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// The HAR types follow http://www.softwareishard.com/blog/har-12-spec/.
+type harLog struct {
+	Log harContent `json:"log"`
+}
+
+type harContent struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	Cookies     []harNameValue `json:"cookies"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	Cookies     []harNameValue `json:"cookies"`
+	Content     harBody        `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harBody struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+func newHAR(entries []inspectEntry) harLog {
+	out := harLog{Log: harContent{
+		Version: "1.2",
+		Creator: harCreator{Name: "air", Version: "dev"},
+		Entries: make([]harEntry, 0, len(entries)),
+	}}
+	for _, e := range entries {
+		ms := float64(e.Duration) / float64(time.Millisecond)
+		entry := harEntry{
+			StartedDateTime: e.Started.Format(time.RFC3339Nano),
+			Time:            ms,
+			Request: harRequest{
+				Method:      e.Method,
+				URL:         e.URL,
+				HTTPVersion: e.Proto,
+				Headers:     harHeaders(e.RequestHeader),
+				QueryString: harQuery(e.URL),
+				Cookies:     []harNameValue{},
+				HeadersSize: -1,
+				BodySize:    e.RequestBody.Size,
+			},
+			Response: harResponse{
+				Status:      e.Status,
+				StatusText:  http.StatusText(e.Status),
+				HTTPVersion: e.Proto,
+				Headers:     harHeaders(e.ResponseHeader),
+				Cookies:     []harNameValue{},
+				Content:     harContentBody(e.ResponseHeader.Get("Content-Type"), e.ResponseBody),
+				RedirectURL: e.ResponseHeader.Get("Location"),
+				HeadersSize: -1,
+				BodySize:    e.ResponseBody.Size,
+			},
+			Timings: harTimings{Wait: ms},
+		}
+		if e.RequestBody.Size > 0 {
+			entry.Request.PostData = &harPostData{
+				MimeType: e.RequestHeader.Get("Content-Type"),
+				Text:     harText(e.RequestBody.Data),
+			}
+		}
+		out.Log.Entries = append(out.Log.Entries, entry)
+	}
+	return out
+}
+
+func harHeaders(h http.Header) []harNameValue {
+	out := make([]harNameValue, 0, len(h))
+	for name, values := range h {
+		for _, v := range values {
+			out = append(out, harNameValue{Name: name, Value: v})
+		}
+	}
+	return out
+}
+
+func harQuery(rawURL string) []harNameValue {
+	out := []harNameValue{}
+	_, query, ok := strings.Cut(rawURL, "?")
+	if !ok {
+		return out
+	}
+	// decoded like url.ParseQuery does, but in the order they were sent
+	for _, pair := range strings.Split(query, "&") {
+		if pair == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(pair, "=")
+		out = append(out, harNameValue{Name: queryUnescape(name), Value: queryUnescape(value)})
+	}
+	return out
+}
+
+// queryUnescape decodes a query component, keeping it as sent if it is
+// malformed.
+func queryUnescape(s string) string {
+	if unescaped, err := url.QueryUnescape(s); err == nil {
+		return unescaped
+	}
+	return s
+}
+
+func harContentBody(contentType string, b capturedBody) harBody {
+	body := harBody{Size: b.Size, MimeType: contentType}
+	if b.Truncated {
+		body.Comment = "truncated by air inspect_body_limit"
+	}
+	if utf8.Valid(b.Data) {
+		body.Text = string(b.Data)
+		return body
+	}
+	body.Text = base64.StdEncoding.EncodeToString(b.Data)
+	body.Encoding = "base64"
+	return body
+}
+
+// harText renders a captured body for display, escaping binary content.
+func harText(data []byte) string {
+	if utf8.Valid(data) {
+		return string(data)
+	}
+	return string(bytes.ToValidUTF8(data, []byte("�")))
+}
@@ -0,0 +1,164 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspectorRingBuffer(t *testing.T) {
+	t.Parallel()
+
+	in := newInspector(3, 10)
+	for i := 0; i < 5; i++ {
+		in.add(inspectEntry{URL: fmt.Sprintf("/%d", i)})
+	}
+
+	entries := in.list()
+	require.Len(t, entries, 3)
+	assert.Equal(t, "/2", entries[0].URL)
+	assert.Equal(t, "/4", entries[2].URL)
+}
+
+func TestCapturedBodyLimit(t *testing.T) {
+	t.Parallel()
+
+	b := capturedBody{limit: 4}
+	_, _ = b.Write([]byte("ab"))
+	_, _ = b.Write([]byte("cdef"))
+
+	assert.Equal(t, "abcd", string(b.Data))
+	assert.Equal(t, int64(6), b.Size)
+	assert.True(t, b.Truncated)
+}
+
+func TestProxy_inspect(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"echo":%q}`, body)
+	}))
+	defer srv.Close()
+
+	proxy := NewProxy(&cfgProxy{
+		Enabled:          true,
+		ProxyPort:        proxyPort,
+		AppPort:          getServerPort(t, srv),
+		Inspect:          true,
+		InspectBodyLimit: 1024,
+	})
+	handler := proxy.inspector.inspect(proxy.proxyHandler)
+
+	req := httptest.NewRequest("POST", fmt.Sprintf("http://localhost:%d/api", proxyPort), strings.NewReader("hello"))
+	req.Header.Set("Content-Type", "text/plain")
+	handler(httptest.NewRecorder(), req)
+
+	entries := proxy.inspector.list()
+	require.Len(t, entries, 1)
+	entry := entries[0]
+	assert.Equal(t, "POST", entry.Method)
+	assert.Equal(t, fmt.Sprintf("http://localhost:%d/api", proxyPort), entry.URL)
+	assert.Equal(t, http.StatusCreated, entry.Status)
+	assert.Equal(t, "hello", string(entry.RequestBody.Data))
+	assert.JSONEq(t, `{"echo":"hello"}`, string(entry.ResponseBody.Data))
+
+	t.Run("page", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		proxy.inspectHandler(rec, httptest.NewRequest("GET", "/__air_internal/inspect", nil))
+		assert.Contains(t, rec.Body.String(), "/api")
+	})
+
+	t.Run("har", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		proxy.harHandler(rec, httptest.NewRequest("GET", "/__air_internal/inspect.har", nil))
+
+		var har harLog
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &har))
+		assert.Equal(t, "1.2", har.Log.Version)
+		require.Len(t, har.Log.Entries, 1)
+		got := har.Log.Entries[0]
+		assert.Equal(t, "POST", got.Request.Method)
+		require.NotNil(t, got.Request.PostData)
+		assert.Equal(t, "hello", got.Request.PostData.Text)
+		assert.Equal(t, http.StatusCreated, got.Response.Status)
+		assert.Equal(t, "application/json", got.Response.Content.MimeType)
+	})
+}
+
+// The app answers before reading the body, so the transport is still sending
+// it while the entry is recorded. Run with -race.
+func TestProxy_inspectUnreadBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		// net/http would read the body before answering, so answer on the
+		// raw connection
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		_, _ = rw.WriteString("HTTP/1.1 413 Request Entity Too Large\r\nContent-Length: 8\r\nConnection: close\r\n\r\ntoo long")
+		_ = rw.Flush()
+		_, _ = io.Copy(io.Discard, rw)
+		_ = conn.Close()
+	}))
+	defer srv.Close()
+
+	proxy := NewProxy(&cfgProxy{
+		Enabled:          true,
+		ProxyPort:        proxyPort,
+		AppPort:          getServerPort(t, srv),
+		Inspect:          true,
+		InspectBodyLimit: 16,
+	})
+	handler := proxy.inspector.inspect(proxy.proxyHandler)
+
+	for range 5 {
+		// the rest of the body trickles in after the app answered
+		pr, pw := io.Pipe()
+		go func() {
+			_, _ = pw.Write([]byte(strings.Repeat("x", 32)))
+			// longer than the transport waits for the body to be sent
+			time.Sleep(100 * time.Millisecond)
+			_, _ = pw.Write([]byte(strings.Repeat("x", 32)))
+			_ = pw.Close()
+		}()
+		req := httptest.NewRequest("POST", fmt.Sprintf("http://localhost:%d/upload", proxyPort), pr)
+		handler(httptest.NewRecorder(), req)
+	}
+
+	entries := proxy.inspector.list()
+	require.Len(t, entries, 5)
+	for _, entry := range entries {
+		assert.Equal(t, http.StatusRequestEntityTooLarge, entry.Status)
+		assert.Equal(t, strings.Repeat("x", 16), string(entry.RequestBody.Data))
+		assert.True(t, entry.RequestBody.Truncated)
+	}
+}
+
+func TestHARQuery(t *testing.T) {
+	t.Parallel()
+
+	assert.Empty(t, harQuery("http://localhost/"))
+	assert.Equal(t, []harNameValue{{Name: "q", Value: "air"}, {Name: "x", Value: ""}}, harQuery("http://localhost/?q=air&x"))
+	assert.Equal(t, []harNameValue{
+		{Name: "q", Value: "live reload"},
+		{Name: "tag[]", Value: "a&b"},
+		{Name: "bad", Value: "%zz"},
+	}, harQuery("http://localhost/?q=live%20reload&&tag%5B%5D=a%26b&bad=%zz"))
+}
+
+func TestHARContentBodyBinary(t *testing.T) {
+	t.Parallel()
+
+	body := harContentBody("image/png", capturedBody{Data: []byte{0xff, 0xfe}, Size: 2})
+	assert.Equal(t, "base64", body.Encoding)
+	assert.Equal(t, "//4=", body.Text)
+}
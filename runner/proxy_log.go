@@ -0,0 +1,105 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+const internalPathPrefix = "/__air_internal/"
+
+// staticAssetExts are left out of the access log unless access_log_static is
+// set, so a page load shows up as one line instead of dozens.
+var staticAssetExts = map[string]bool{
+	".css": true, ".js": true, ".mjs": true, ".map": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true,
+	".ico": true, ".webp": true, ".avif": true,
+	".woff": true, ".woff2": true, ".ttf": true, ".otf": true, ".eot": true,
+}
+
+// proxyTrace collects what proxyHandler learns about a request for the
+// access log.
+type proxyTrace struct {
+	upstream time.Duration
+	injected bool
+}
+
+type proxyTraceKey struct{}
+
+func traceFrom(ctx context.Context) *proxyTrace {
+	if tr, ok := ctx.Value(proxyTraceKey{}).(*proxyTrace); ok {
+		return tr
+	}
+	// a throwaway trace keeps callers free of nil checks
+	return &proxyTrace{}
+}
+
+// log prints through air's proxy logger. It is a no-op until the engine
+// sets logf.
+func (p *Proxy) log(format string, v ...interface{}) {
+	if p.logf != nil {
+		p.logf(format, v...)
+	}
+}
+
+// accessLog wraps next so that the requests it serves are logged.
+func (p *Proxy) accessLog(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !p.shouldLogAccess(r.URL.Path) {
+			next(w, r)
+			return
+		}
+		start := time.Now()
+		tr := &proxyTrace{}
+		rw := &recordingWriter{ResponseWriter: w}
+		next(rw, r.WithContext(context.WithValue(r.Context(), proxyTraceKey{}, tr)))
+		p.log("%s", formatAccessLog(r, rw.status, rw.body.Size, time.Since(start), tr))
+	}
+}
+
+func (p *Proxy) shouldLogAccess(urlPath string) bool {
+	if !p.config.AccessLog {
+		return false
+	}
+	if strings.HasPrefix(urlPath, internalPathPrefix) {
+		return p.config.AccessLogInternal
+	}
+	if staticAssetExts[strings.ToLower(path.Ext(urlPath))] {
+		return p.config.AccessLogStatic
+	}
+	return true
+}
+
+func formatAccessLog(r *http.Request, status int, size int64, total time.Duration, tr *proxyTrace) string {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	line := fmt.Sprintf("[proxy] %s %s %d %s %s", r.Method, r.URL.RequestURI(), status, formatLatency(total, tr.upstream), formatBytes(size))
+	if tr.injected {
+		line += " +reload"
+	}
+	return line
+}
+
+// formatLatency shows the upstream latency when it is known, next to the
+// total time the proxy spent on the request.
+func formatLatency(total, upstream time.Duration) string {
+	if upstream == 0 {
+		return total.Round(time.Millisecond / 10).String()
+	}
+	return fmt.Sprintf("%s (upstream %s)", total.Round(time.Millisecond/10), upstream.Round(time.Millisecond/10))
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n < 1<<10:
+		return fmt.Sprintf("%dB", n)
+	case n < 1<<20:
+		return fmt.Sprintf("%.1fKB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	}
+}
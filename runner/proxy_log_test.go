@@ -0,0 +1,77 @@
+package runner
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxy_shouldLogAccess(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		cfg    cfgProxy
+		path   string
+		expect bool
+	}{
+		{name: "disabled", cfg: cfgProxy{}, path: "/", expect: false},
+		{name: "page", cfg: cfgProxy{AccessLog: true}, path: "/users", expect: true},
+		{name: "static_filtered", cfg: cfgProxy{AccessLog: true}, path: "/app.CSS", expect: false},
+		{name: "static_included", cfg: cfgProxy{AccessLog: true, AccessLogStatic: true}, path: "/app.css", expect: true},
+		{name: "internal_filtered", cfg: cfgProxy{AccessLog: true}, path: "/__air_internal/sse", expect: false},
+		{name: "internal_included", cfg: cfgProxy{AccessLog: true, AccessLogInternal: true}, path: "/__air_internal/sse", expect: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			proxy := &Proxy{config: &tt.cfg}
+			assert.Equal(t, tt.expect, proxy.shouldLogAccess(tt.path))
+		})
+	}
+}
+
+func TestProxy_accessLog(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<body>hi</body>")
+	}))
+	defer srv.Close()
+
+	var lines []string
+	proxy := NewProxy(&cfgProxy{
+		Enabled:   true,
+		ProxyPort: proxyPort,
+		AppPort:   getServerPort(t, srv),
+		AccessLog: true,
+	})
+	proxy.logf = func(format string, v ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, v...))
+	}
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("http://localhost:%d/page?x=%%41", proxyPort), nil)
+	proxy.accessLog(proxy.proxyHandler)(httptest.NewRecorder(), req)
+
+	require.Len(t, lines, 1)
+	assert.Regexp(t, `^\[proxy\] GET /page\?x=%41 200 \S+ \(upstream \S+\) \d+(\.\d)?K?B \+reload$`, lines[0])
+}
+
+func TestFormatBytes(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "512B", formatBytes(512))
+	assert.Equal(t, "1.5KB", formatBytes(1536))
+	assert.Equal(t, "2.0MB", formatBytes(2<<20))
+}
+
+func TestFormatLatency(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "12ms", formatLatency(12*time.Millisecond, 0))
+	assert.Equal(t, "12ms (upstream 10ms)", formatLatency(12*time.Millisecond, 10*time.Millisecond))
+}
@@ -0,0 +1,60 @@
+package runner
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// openBrowser opens the proxy in the browser, once per air session, when
+// proxy.open_browser is set and a build succeeded. An app still running from
+// before a failed build isn't worth opening. A tab already connected to the
+// stream is reloaded by air, so no new one is opened.
+func (p *Proxy) openBrowser() {
+	if !p.config.OpenBrowser || !p.built.Load() {
+		return
+	}
+	p.opened.Do(func() {
+		if p.stream.Subscribers() > 0 {
+			return
+		}
+		target := p.URL()
+		if strings.HasPrefix(target, "unix:") {
+			p.log("not opening the browser, the proxy listens on %s", target)
+			return
+		}
+		target += p.config.OpenPath
+		if err := p.open(target); err != nil {
+			p.log("failed to open %s in the browser: %s", target, err)
+		}
+	})
+}
+
+// startOpener runs the command opening target, without waiting for it.
+func (p *Proxy) startOpener(target string) error {
+	args := openerArgs(runtime.GOOS, p.config.OpenCommand, target)
+	cmd := exec.Command(args[0], args[1:]...)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		_ = cmd.Wait()
+	}()
+	return nil
+}
+
+// openerArgs returns the command line opening target in the browser, with
+// command overriding the platform's default opener.
+func openerArgs(goos, command, target string) []string {
+	if fields := strings.Fields(command); len(fields) > 0 {
+		return append(fields, target)
+	}
+	switch goos {
+	case "darwin":
+		return []string{"open", target}
+	case "windows":
+		return []string{"rundll32", "url.dll,FileProtocolHandler", target}
+	default:
+		return []string{"xdg-open", target}
+	}
+}
@@ -0,0 +1,82 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenerArgs(t *testing.T) {
+	t.Parallel()
+
+	url := "http://localhost:8090/"
+	assert.Equal(t, []string{"xdg-open", url}, openerArgs("linux", "", url))
+	assert.Equal(t, []string{"open", url}, openerArgs("darwin", "", url))
+	assert.Equal(t, []string{"rundll32", "url.dll,FileProtocolHandler", url}, openerArgs("windows", "", url))
+	assert.Equal(t, []string{"firefox", "--new-tab", url}, openerArgs("linux", " firefox --new-tab ", url))
+}
+
+// newOpenProxy returns a proxy that records the pages it opens, after a
+// successful build.
+func newOpenProxy(cfg *cfgProxy) (*Proxy, *[]string) {
+	var opened []string
+	proxy := NewProxy(cfg)
+	proxy.open = func(target string) error {
+		opened = append(opened, target)
+		return nil
+	}
+	proxy.BuildSucceeded()
+	return proxy, &opened
+}
+
+func TestProxy_openBrowser(t *testing.T) {
+	t.Run("once", func(t *testing.T) {
+		proxy, opened := newOpenProxy(&cfgProxy{Enabled: true, ProxyPort: 8090, OpenBrowser: true, OpenPath: "/admin?tab=1"})
+		proxy.openBrowser()
+		proxy.openBrowser()
+		assert.Equal(t, []string{"http://localhost:8090/admin?tab=1"}, *opened)
+	})
+
+	t.Run("not_built", func(t *testing.T) {
+		var opened []string
+		proxy := NewProxy(&cfgProxy{Enabled: true, ProxyPort: 8090, OpenBrowser: true})
+		proxy.open = func(target string) error {
+			opened = append(opened, target)
+			return nil
+		}
+		proxy.BuildFailed(BuildFailedMsg{Error: "exit status 1"})
+		proxy.openBrowser()
+		assert.Empty(t, opened)
+
+		proxy.BuildSucceeded()
+		proxy.openBrowser()
+		assert.Equal(t, []string{"http://localhost:8090"}, opened)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		proxy, opened := newOpenProxy(&cfgProxy{Enabled: true, ProxyPort: 8090})
+		proxy.openBrowser()
+		assert.Empty(t, *opened)
+	})
+
+	t.Run("tab_connected", func(t *testing.T) {
+		proxy, opened := newOpenProxy(&cfgProxy{Enabled: true, ProxyPort: 8090, OpenBrowser: true})
+		proxy.stream.AddSubscriber()
+		proxy.openBrowser()
+		assert.Empty(t, *opened)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		var lines []string
+		proxy := NewProxy(&cfgProxy{Enabled: true, ProxyPort: 8090, OpenBrowser: true})
+		proxy.open = func(string) error { return errors.New("xdg-open not found") }
+		proxy.logf = func(format string, v ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, v...))
+		}
+		proxy.BuildSucceeded()
+		proxy.openBrowser()
+		assert.Equal(t, []string{"failed to open http://localhost:8090 in the browser: xdg-open not found"}, lines)
+	})
+}
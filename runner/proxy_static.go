@@ -0,0 +1,80 @@
+package runner
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// matchStatic returns the static dir with the longest path prefix matching
+// urlPath.
+func (p *Proxy) matchStatic(urlPath string) (cfgProxyStatic, bool) {
+	var (
+		best  cfgProxyStatic
+		found bool
+	)
+	for _, s := range p.config.Static {
+		if pathHasPrefix(urlPath, s.Path) && (!found || len(s.Path) > len(best.Path)) {
+			best, found = s, true
+		}
+	}
+	return best, found
+}
+
+// serveStatic serves r from the static dir s. Browsers revalidate every
+// response, so they never show a stale file, and HTML pages get the live
+// reload script.
+func (p *Proxy) serveStatic(w http.ResponseWriter, r *http.Request, s cfgProxyStatic) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "static handler: method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := path.Clean("/" + strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(s.Path, "/")))
+	file := filepath.Join(s.Dir, filepath.FromSlash(name))
+	info, err := os.Stat(file)
+	if err == nil && info.IsDir() {
+		// relative links in the index resolve against the dir
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+			return
+		}
+		file = filepath.Join(file, "index.html")
+		info, err = os.Stat(file)
+	}
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		http.Error(w, "static handler: unable to open "+name, http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Cache-Control", "no-cache")
+	if ext := strings.ToLower(filepath.Ext(file)); ext != ".html" && ext != ".htm" {
+		http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+		return
+	}
+	resp := &http.Response{
+		Header: http.Header{"Content-Type": []string{"text/html; charset=utf-8"}},
+		Body:   f,
+	}
+	page, _, _, err := p.injectLiveReload(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(page)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		_, _ = io.WriteString(w, page)
+	}
+}
@@ -0,0 +1,74 @@
+package runner
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newStaticProxy returns a proxy serving a static dir at /assets, in front of
+// an app that is down.
+func newStaticProxy(t *testing.T) *Proxy {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "docs"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.css"), []byte("body { color: red }"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "docs", "index.html"), []byte("<html><body>docs</body></html>"), 0o644))
+
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	appPort := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	return NewProxy(&cfgProxy{
+		Enabled:         true,
+		ProxyPort:       proxyPort,
+		AppPort:         appPort,
+		AppStartTimeout: 1,
+		Static:          []cfgProxyStatic{{Path: "/assets", Dir: dir}},
+	})
+}
+
+func TestProxy_proxyHandler_Static(t *testing.T) {
+	proxy := newStaticProxy(t)
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		proxy.proxyHandler(rec, httptest.NewRequest("GET", target, nil))
+		return rec
+	}
+
+	t.Run("file", func(t *testing.T) {
+		rec := get("/assets/app.css")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "body { color: red }", rec.Body.String())
+		assert.Equal(t, "no-cache", rec.Header().Get("Cache-Control"))
+		assert.Contains(t, rec.Header().Get("Content-Type"), "text/css")
+		assert.NotEmpty(t, rec.Header().Get("Last-Modified"))
+	})
+
+	t.Run("index_with_live_reload", func(t *testing.T) {
+		rec := get("/assets/docs/")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "docs<script>"+ProxyScript+"</script></body>")
+	})
+
+	t.Run("dir_redirect", func(t *testing.T) {
+		rec := get("/assets/docs")
+		assert.Equal(t, http.StatusMovedPermanently, rec.Code)
+		assert.Equal(t, "/assets/docs/", rec.Header().Get("Location"))
+	})
+
+	t.Run("not_found", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, get("/assets/missing.js").Code)
+		assert.Equal(t, http.StatusNotFound, get("/assets/../../etc/passwd").Code)
+	})
+
+	t.Run("app_down", func(t *testing.T) {
+		assert.Equal(t, http.StatusInternalServerError, get("/api").Code)
+	})
+}
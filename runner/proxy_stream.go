@@ -3,24 +3,55 @@ package runner
 import (
 	"encoding/json"
 	"fmt"
+	"slices"
+	"strconv"
 	"sync"
 	"sync/atomic"
 )
 
+// subscriberQueueSize bounds the messages waiting for a subscriber. Reloads
+// and statuses coalesce, so only a subscriber that stopped reading through a
+// burst of asset changes fills it up, and is dropped.
+const subscriberQueueSize = 16
+
 type ProxyStream struct {
 	mu          sync.Mutex
 	subscribers map[int32]*Subscriber
 	count       atomic.Int32
+	// seq numbers the messages sent, which become their SSE ids
+	seq atomic.Uint64
+	// status is the last build or app status message, replayed to new
+	// subscribers so that they start out in the current state.
+	status *StreamMessage
 }
 
 type StreamMessageType string
 
 const (
-	StreamMessageReload      StreamMessageType = "reload"
-	StreamMessageBuildFailed StreamMessageType = "build-failed"
+	StreamMessageReload        StreamMessageType = "reload"
+	StreamMessageBuildFailed   StreamMessageType = "build-failed"
+	StreamMessageAssetsChanged StreamMessageType = "assets-changed"
+
+	StreamMessageBuildStarted   StreamMessageType = "build-started"
+	StreamMessageBuildSucceeded StreamMessageType = "build-succeeded"
+	StreamMessageAppStarting    StreamMessageType = "app-starting"
+	StreamMessageAppReady       StreamMessageType = "app-ready"
+	StreamMessageRuntimeError   StreamMessageType = "runtime-error"
 )
 
+// isStatus reports whether t describes the state of the build or the app,
+// as opposed to asking the browser to do something.
+func (t StreamMessageType) isStatus() bool {
+	switch t {
+	case StreamMessageBuildStarted, StreamMessageBuildFailed, StreamMessageBuildSucceeded,
+		StreamMessageAppStarting, StreamMessageAppReady, StreamMessageRuntimeError:
+		return true
+	}
+	return false
+}
+
 type StreamMessage struct {
+	ID   uint64
 	Type StreamMessageType
 	Data interface{}
 }
@@ -29,11 +60,131 @@ type BuildFailedMsg struct {
 	Error   string `json:"error"`
 	Command string `json:"command"`
 	Output  string `json:"output"`
+	// Overlay shows the error over the page rather than only in the status
+	// badge.
+	Overlay bool `json:"overlay"`
+}
+
+// ReloadMsg tells the browser how to reload the page.
+type ReloadMsg struct {
+	// PreserveState restores scroll offsets, form fields and open <details>
+	// elements after the reload.
+	PreserveState bool `json:"preserve_state"`
+}
+
+// AssetsChangedMsg lists changed asset paths, relative to the root, which the
+// browser swaps in place instead of reloading the page.
+type AssetsChangedMsg struct {
+	Paths []string `json:"paths"`
+}
+
+// RuntimeErrorMsg is a panic, fatal runtime error or log.Fatal of the app,
+// parsed from its stderr.
+type RuntimeErrorMsg struct {
+	// Kind is "panic", "fatal error" or "log.Fatal".
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	// Goroutine is the header of the failing goroutine, e.g.
+	// "goroutine 1 [running]".
+	Goroutine string       `json:"goroutine"`
+	Frames    []StackFrame `json:"frames"`
+	// Output is the trace as the app printed it.
+	Output string `json:"output"`
+	// Exited reports whether the app exited, rather than recovering.
+	Exited bool `json:"exited"`
+}
+
+// StackFrame is a function call in the stack of a RuntimeErrorMsg.
+type StackFrame struct {
+	Function string `json:"function"`
+	// File is relative to the root for the project's own files.
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Project bool   `json:"project"`
 }
 
 type Subscriber struct {
-	id    int32
-	msgCh chan StreamMessage
+	id int32
+	// notify is signalled when messages are queued.
+	notify chan struct{}
+	// done is closed once the subscriber is removed or dropped.
+	done chan struct{}
+	// replay is the status at the time the subscriber was added, if any.
+	replay *StreamMessage
+
+	mu     sync.Mutex
+	queue  []StreamMessage
+	closed bool
+}
+
+func newSubscriber(id int32, replay *StreamMessage) *Subscriber {
+	return &Subscriber{
+		id:     id,
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		replay: replay,
+	}
+}
+
+// push queues msg without blocking, dropping queued messages that msg makes
+// redundant. It returns false if the queue is full.
+func (sub *Subscriber) push(msg StreamMessage) bool {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.closed {
+		return true
+	}
+	// the page is about to reload, swapping its assets is pointless
+	if msg.Type == StreamMessageAssetsChanged && slices.ContainsFunc(sub.queue, func(m StreamMessage) bool {
+		return m.Type == StreamMessageReload
+	}) {
+		return true
+	}
+	sub.queue = slices.DeleteFunc(sub.queue, func(m StreamMessage) bool {
+		return supersedes(msg.Type, m.Type)
+	})
+	if len(sub.queue) == subscriberQueueSize {
+		return false
+	}
+	sub.queue = append(sub.queue, msg)
+
+	select {
+	case sub.notify <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// drain returns the queued messages, oldest first, and empties the queue.
+func (sub *Subscriber) drain() []StreamMessage {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	msgs := sub.queue
+	sub.queue = nil
+	return msgs
+}
+
+func (sub *Subscriber) close() {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if !sub.closed {
+		sub.closed = true
+		sub.queue = nil
+		close(sub.done)
+	}
+}
+
+// supersedes reports whether a message of type next makes a queued message
+// of type prev redundant: only the latest status matters, and a reload covers
+// any reload or asset swap before it.
+func supersedes(next, prev StreamMessageType) bool {
+	switch {
+	case next.isStatus():
+		return prev.isStatus()
+	case next == StreamMessageReload:
+		return prev == StreamMessageReload || prev == StreamMessageAssetsChanged
+	}
+	return false
 }
 
 func NewProxyStream() *ProxyStream {
@@ -41,10 +192,14 @@ func NewProxyStream() *ProxyStream {
 }
 
 func (stream *ProxyStream) Stop() {
-	for id := range stream.subscribers {
-		stream.RemoveSubscriber(id)
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	for id, sub := range stream.subscribers {
+		sub.close()
+		delete(stream.subscribers, id)
 	}
-	stream.count = atomic.Int32{}
+	stream.count.Store(0)
 }
 
 func (stream *ProxyStream) AddSubscriber() *Subscriber {
@@ -52,7 +207,7 @@ func (stream *ProxyStream) AddSubscriber() *Subscriber {
 	defer stream.mu.Unlock()
 	stream.count.Add(1)
 
-	sub := &Subscriber{id: stream.count.Load(), msgCh: make(chan StreamMessage)}
+	sub := newSubscriber(stream.count.Load(), stream.status)
 	stream.subscribers[stream.count.Load()] = sub
 	return sub
 }
@@ -61,32 +216,75 @@ func (stream *ProxyStream) RemoveSubscriber(id int32) {
 	stream.mu.Lock()
 	defer stream.mu.Unlock()
 
-	if _, ok := stream.subscribers[id]; ok {
-		close(stream.subscribers[id].msgCh)
+	if sub, ok := stream.subscribers[id]; ok {
+		sub.close()
 		delete(stream.subscribers, id)
 	}
 }
 
-func (stream *ProxyStream) Reload() {
-	for _, sub := range stream.subscribers {
-		sub.msgCh <- StreamMessage{
-			Type: StreamMessageReload,
-			Data: nil,
-		}
-	}
+// Subscribers returns the number of browser tabs connected to the stream.
+func (stream *ProxyStream) Subscribers() int {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	return len(stream.subscribers)
+}
+
+func (stream *ProxyStream) Reload(msg ReloadMsg) {
+	stream.broadcast(StreamMessageReload, msg)
 }
 
 func (stream *ProxyStream) BuildFailed(err BuildFailedMsg) {
-	for _, sub := range stream.subscribers {
-		sub.msgCh <- StreamMessage{
-			Type: StreamMessageBuildFailed,
-			Data: err,
+	stream.broadcast(StreamMessageBuildFailed, err)
+}
+
+func (stream *ProxyStream) AssetsChanged(msg AssetsChangedMsg) {
+	stream.broadcast(StreamMessageAssetsChanged, msg)
+}
+
+func (stream *ProxyStream) BuildStarted() {
+	stream.broadcast(StreamMessageBuildStarted, nil)
+}
+
+func (stream *ProxyStream) BuildSucceeded() {
+	stream.broadcast(StreamMessageBuildSucceeded, nil)
+}
+
+func (stream *ProxyStream) AppStarting() {
+	stream.broadcast(StreamMessageAppStarting, nil)
+}
+
+func (stream *ProxyStream) AppReady() {
+	stream.broadcast(StreamMessageAppReady, nil)
+}
+
+func (stream *ProxyStream) RuntimeError(msg RuntimeErrorMsg) {
+	stream.broadcast(StreamMessageRuntimeError, msg)
+}
+
+func (stream *ProxyStream) broadcast(typ StreamMessageType, data interface{}) {
+	msg := StreamMessage{ID: stream.seq.Add(1), Type: typ, Data: data}
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if typ.isStatus() {
+		stream.status = &msg
+	}
+	for id, sub := range stream.subscribers {
+		if !sub.push(msg) {
+			// the browser stopped reading. Dropping it ends its request, and
+			// a live tab reconnects and picks up the current status.
+			sub.close()
+			delete(stream.subscribers, id)
 		}
 	}
 }
 
 func (m StreamMessage) AsSSE() string {
-	s := "event: " + string(m.Type) + "\n"
+	s := ""
+	if m.ID != 0 {
+		s += "id: " + strconv.FormatUint(m.ID, 10) + "\n"
+	}
+	s += "event: " + string(m.Type) + "\n"
 	s += "data: " + stringify(m.Data) + "\n"
 	return s + "\n"
 }
@@ -4,6 +4,7 @@ import (
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -34,23 +35,18 @@ func TestProxyStream(t *testing.T) {
 		t.Errorf("expect subscribers count to be %d, got %d", exp, got)
 	}
 
-	doneCh := make(chan struct{})
-	go func() {
-		stream.Reload()
-		doneCh <- struct{}{}
-	}()
+	stream.Reload(ReloadMsg{})
 
 	var reloadCount atomic.Int32
 	for _, sub := range stream.subscribers {
 		wg.Add(1)
 		go func(sub *Subscriber) {
 			defer wg.Done()
-			<-sub.msgCh
-			reloadCount.Add(1)
+			<-sub.notify
+			reloadCount.Add(int32(len(sub.drain())))
 		}(sub)
 	}
 	wg.Wait()
-	<-doneCh
 
 	if got, exp := reloadCount.Load(), int32(10); got != exp {
 		t.Errorf("expect reloadCount %d, got %d", exp, got)
@@ -82,18 +78,68 @@ func TestBuildFailureMessage(t *testing.T) {
 		Output:  "error output",
 	}
 
-	go stream.BuildFailed(msg)
+	stream.BuildFailed(msg)
 
-	received := <-sub.msgCh
+	received := sub.drain()[0]
 	assert.Equal(t, StreamMessageBuildFailed, received.Type)
 	assert.Equal(t, msg, received.Data)
 }
 
+func TestReloadMessage(t *testing.T) {
+	stream := NewProxyStream()
+	sub := stream.AddSubscriber()
+
+	stream.Reload(ReloadMsg{PreserveState: true})
+
+	received := sub.drain()[0]
+	assert.Equal(t, StreamMessageReload, received.Type)
+	assert.Equal(t, "id: 1\nevent: reload\ndata: {\"preserve_state\":true}\n\n", received.AsSSE())
+}
+
+func TestAssetsChangedMessage(t *testing.T) {
+	stream := NewProxyStream()
+	sub := stream.AddSubscriber()
+
+	msg := AssetsChangedMsg{Paths: []string{"web/app.css"}}
+	stream.AssetsChanged(msg)
+
+	received := sub.drain()[0]
+	assert.Equal(t, StreamMessageAssetsChanged, received.Type)
+	assert.Equal(t, "id: 1\nevent: assets-changed\ndata: {\"paths\":[\"web/app.css\"]}\n\n", received.AsSSE())
+}
+
 func TestStreamMessageAsSSE(t *testing.T) {
 	t.Parallel()
 
 	msg := StreamMessage{Type: StreamMessageReload, Data: nil}
 	assert.Equal(t, "event: reload\ndata: null\n\n", msg.AsSSE())
+
+	msg.ID = 7
+	assert.Equal(t, "id: 7\nevent: reload\ndata: null\n\n", msg.AsSSE())
+}
+
+func TestProxyStreamStatusReplay(t *testing.T) {
+	t.Parallel()
+
+	stream := NewProxyStream()
+	assert.Nil(t, stream.AddSubscriber().replay)
+
+	stream.RemoveSubscriber(1)
+	stream.BuildStarted()
+	stream.BuildFailed(BuildFailedMsg{Error: "err"})
+	// only status messages are replayed
+	stream.AssetsChanged(AssetsChangedMsg{Paths: []string{"app.css"}})
+
+	sub := stream.AddSubscriber()
+	if assert.NotNil(t, sub.replay) {
+		assert.Equal(t, uint64(2), sub.replay.ID)
+		assert.Equal(t, StreamMessageBuildFailed, sub.replay.Type)
+	}
+
+	stream.AppReady()
+	received := sub.drain()[0]
+	assert.Equal(t, uint64(4), received.ID)
+	assert.Equal(t, StreamMessageAppReady, received.Type)
 }
 
 func TestStringifyMarshalError(t *testing.T) {
@@ -122,10 +168,145 @@ func TestProxyStreamReloadAndBuildFailedNoSubscribers(t *testing.T) {
 	stream := NewProxyStream()
 
 	assert.NotPanics(t, func() {
-		stream.Reload()
+		stream.Reload(ReloadMsg{})
 	})
 
 	assert.NotPanics(t, func() {
 		stream.BuildFailed(BuildFailedMsg{Error: "err", Command: "go build", Output: "out"})
 	})
 }
+
+func messageTypes(msgs []StreamMessage) []StreamMessageType {
+	types := make([]StreamMessageType, len(msgs))
+	for i, msg := range msgs {
+		types[i] = msg.Type
+	}
+	return types
+}
+
+func TestSubscriberCoalescing(t *testing.T) {
+	t.Parallel()
+
+	t.Run("latest status and reload", func(t *testing.T) {
+		sub := newSubscriber(1, nil)
+		sub.push(StreamMessage{Type: StreamMessageBuildStarted})
+		sub.push(StreamMessage{Type: StreamMessageAssetsChanged})
+		sub.push(StreamMessage{Type: StreamMessageBuildSucceeded})
+		sub.push(StreamMessage{Type: StreamMessageReload})
+		sub.push(StreamMessage{Type: StreamMessageAppStarting})
+		sub.push(StreamMessage{Type: StreamMessageReload})
+
+		assert.Equal(t, []StreamMessageType{StreamMessageAppStarting, StreamMessageReload}, messageTypes(sub.drain()))
+		assert.Empty(t, sub.drain())
+	})
+
+	t.Run("assets after a pending reload", func(t *testing.T) {
+		sub := newSubscriber(1, nil)
+		sub.push(StreamMessage{Type: StreamMessageReload})
+		sub.push(StreamMessage{Type: StreamMessageAssetsChanged})
+
+		assert.Equal(t, []StreamMessageType{StreamMessageReload}, messageTypes(sub.drain()))
+	})
+
+	t.Run("full queue", func(t *testing.T) {
+		sub := newSubscriber(1, nil)
+		for i := 0; i < subscriberQueueSize; i++ {
+			assert.True(t, sub.push(StreamMessage{Type: StreamMessageAssetsChanged}))
+		}
+		assert.False(t, sub.push(StreamMessage{Type: StreamMessageAssetsChanged}))
+		// a reload still fits, since it replaces the queued asset swaps
+		assert.True(t, sub.push(StreamMessage{Type: StreamMessageReload}))
+	})
+}
+
+func TestProxyStreamDropsSlowSubscribers(t *testing.T) {
+	t.Parallel()
+
+	stream := NewProxyStream()
+	slow := stream.AddSubscriber()
+	fast := stream.AddSubscriber()
+
+	for i := 0; i <= subscriberQueueSize; i++ {
+		stream.AssetsChanged(AssetsChangedMsg{Paths: []string{"app.css"}})
+		fast.drain()
+	}
+
+	select {
+	case <-slow.done:
+	default:
+		t.Fatal("expected the slow subscriber to be dropped")
+	}
+	assert.False(t, find(stream.subscribers, slow.id))
+	assert.True(t, find(stream.subscribers, fast.id))
+}
+
+func TestProxyStreamStress(t *testing.T) {
+	t.Parallel()
+
+	const subscribers = 5000
+	stream := NewProxyStream()
+
+	// half the subscribers read until the final build-failed, the other
+	// half never read
+	var readers, churn sync.WaitGroup
+	for i := 0; i < subscribers; i++ {
+		sub := stream.AddSubscriber()
+		if i%2 == 1 {
+			continue
+		}
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-sub.notify:
+					for _, msg := range sub.drain() {
+						if msg.Type == StreamMessageBuildFailed {
+							return
+						}
+					}
+				case <-sub.done:
+					t.Error("reading subscriber was dropped")
+					return
+				}
+			}
+		}()
+	}
+
+	// subscribers coming and going while broadcasting
+	churn.Add(1)
+	go func() {
+		defer churn.Done()
+		for i := 0; i < 500; i++ {
+			stream.RemoveSubscriber(stream.AddSubscriber().id)
+		}
+	}()
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		for i := 0; i < 20; i++ {
+			stream.BuildStarted()
+			stream.AssetsChanged(AssetsChangedMsg{Paths: []string{"app.css"}})
+			stream.Reload(ReloadMsg{})
+			stream.AppReady()
+		}
+		stream.BuildFailed(BuildFailedMsg{Error: "done"})
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(30 * time.Second):
+		t.Fatal("broadcasting blocked")
+	}
+
+	readers.Wait()
+	churn.Wait()
+
+	stream.mu.Lock()
+	remaining := len(stream.subscribers)
+	stream.mu.Unlock()
+	// reloads and statuses coalesce, so idle subscribers are kept
+	assert.Equal(t, subscribers, remaining)
+	stream.Stop()
+}
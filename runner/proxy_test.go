@@ -3,13 +3,16 @@ package runner
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -22,22 +25,29 @@ import (
 )
 
 type reloader struct {
-	subCh    chan struct{}
-	reloadCh chan StreamMessage
+	subCh chan struct{}
+	sub   *Subscriber
 }
 
 func (r *reloader) AddSubscriber() *Subscriber {
 	r.subCh <- struct{}{}
-	return &Subscriber{msgCh: r.reloadCh}
+	return r.sub
 }
 
 func (r *reloader) RemoveSubscriber(_ int32) {
 	close(r.subCh)
 }
 
-func (r *reloader) Reload()                    {}
-func (r *reloader) BuildFailed(BuildFailedMsg) {}
-func (r *reloader) Stop()                      {}
+func (r *reloader) Subscribers() int               { return 0 }
+func (r *reloader) Reload(ReloadMsg)               {}
+func (r *reloader) BuildStarted()                  {}
+func (r *reloader) BuildSucceeded()                {}
+func (r *reloader) AppStarting()                   {}
+func (r *reloader) AppReady()                      {}
+func (r *reloader) RuntimeError(RuntimeErrorMsg)   {}
+func (r *reloader) BuildFailed(BuildFailedMsg)     {}
+func (r *reloader) AssetsChanged(AssetsChangedMsg) {}
+func (r *reloader) Stop()                          {}
 
 var proxyPort = 8090
 
@@ -164,9 +174,10 @@ func TestProxy_proxyHandler(t *testing.T) {
 
 func TestProxy_injectLiveReload(t *testing.T) {
 	tests := []struct {
-		name   string
-		given  *http.Response
-		expect string
+		name     string
+		given    *http.Response
+		expect   string
+		injected bool
 	}{
 		{
 			name: "when_no_body_should_not_be_injected",
@@ -205,7 +216,8 @@ func TestProxy_injectLiveReload(t *testing.T) {
 				},
 				Body: io.NopCloser(strings.NewReader(`<body><h1>test</h1></body>`)),
 			},
-			expect: fmt.Sprintf(`<body><h1>test</h1><script>%s</script></body>`, ProxyScript),
+			expect:   fmt.Sprintf(`<body><h1>test</h1><script>%s</script></body>`, ProxyScript),
+			injected: true,
 		},
 	}
 	for _, tt := range tests {
@@ -215,7 +227,8 @@ func TestProxy_injectLiveReload(t *testing.T) {
 				ProxyPort: 1111,
 				AppPort:   2222,
 			})
-			got, _, _ := proxy.injectLiveReload(tt.given)
+			got, _, injected, _ := proxy.injectLiveReload(tt.given)
+			assert.Equal(t, tt.injected, injected)
 			if got != tt.expect {
 				// Use a more descriptive error message
 				if len(got) > 100 || len(tt.expect) > 100 {
@@ -236,7 +249,7 @@ func TestProxy_reloadHandler(t *testing.T) {
 	srvPort := getServerPort(t, srv)
 	defer srv.Close()
 
-	reloader := &reloader{subCh: make(chan struct{}), reloadCh: make(chan StreamMessage)}
+	reloader := &reloader{subCh: make(chan struct{}), sub: newSubscriber(1, nil)}
 	cfg := &cfgProxy{
 		Enabled:   true,
 		ProxyPort: proxyPort,
@@ -261,11 +274,16 @@ func TestProxy_reloadHandler(t *testing.T) {
 
 	<-reloader.subCh
 
-	reloader.reloadCh <- StreamMessage{
+	reloader.sub.push(StreamMessage{
 		Type: StreamMessageReload,
 		Data: nil,
-	}
-	close(reloader.reloadCh)
+	})
+	require.Eventually(t, func() bool {
+		reloader.sub.mu.Lock()
+		defer reloader.sub.mu.Unlock()
+		return len(reloader.sub.queue) == 0
+	}, time.Second, time.Millisecond)
+	reloader.sub.close()
 	wg.Wait()
 
 	if !rec.Flushed {
@@ -297,6 +315,109 @@ func TestProxy_reloadHandler(t *testing.T) {
 	}
 }
 
+func TestProxy_reloadHandler_StatusAndHeartbeat(t *testing.T) {
+	tests := []struct {
+		name        string
+		lastEventID string
+		expectReply bool
+	}{
+		{name: "new", expectReply: true},
+		{name: "missed_status", lastEventID: "1", expectReply: true},
+		{name: "seen_status", lastEventID: "2", expectReply: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := NewProxyStream()
+			stream.BuildStarted()
+			stream.AppStarting()
+			proxy := &Proxy{config: &cfgProxy{}, stream: stream, heartbeat: 10 * time.Millisecond}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			req := httptest.NewRequest("GET", "/__air_internal/sse", nil).WithContext(ctx)
+			if tt.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", tt.lastEventID)
+			}
+			rec := httptest.NewRecorder()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				proxy.reloadHandler(rec, req)
+			}()
+
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+			<-done
+
+			body := rec.Body.String()
+			assert.Equal(t, tt.expectReply, strings.HasPrefix(body, "id: 2\nevent: app-starting\ndata: null\n\n"))
+			assert.Contains(t, body, ": heartbeat\n\n")
+		})
+	}
+}
+
+func TestProxy_WaitAppReady(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+
+	stream := NewProxyStream()
+	sub := stream.AddSubscriber()
+	proxy := &Proxy{config: &cfgProxy{AppPort: getServerPort(t, srv)}, stream: stream}
+
+	go proxy.WaitAppReady(make(chan struct{}))
+
+	select {
+	case <-sub.notify:
+		assert.Equal(t, StreamMessageAppReady, sub.drain()[0].Type)
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected app-ready")
+	}
+
+	t.Run("app_exited", func(t *testing.T) {
+		l, err := net.Listen("tcp", "localhost:0")
+		require.NoError(t, err)
+		port := l.Addr().(*net.TCPAddr).Port
+		l.Close()
+
+		proxy := &Proxy{config: &cfgProxy{AppPort: port}, stream: NewProxyStream()}
+		exit := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			proxy.WaitAppReady(exit)
+		}()
+		close(exit)
+
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected WaitAppReady to give up once the app exits")
+		}
+	})
+}
+
+func TestProxy_Reload_PreserveState(t *testing.T) {
+	disabled := false
+	tests := []struct {
+		name   string
+		cfg    cfgProxy
+		expect bool
+	}{
+		{name: "default", cfg: cfgProxy{}, expect: true},
+		{name: "disabled", cfg: cfgProxy{PreserveState: &disabled}, expect: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := NewProxyStream()
+			sub := stream.AddSubscriber()
+			proxy := &Proxy{config: &tt.cfg, stream: stream}
+
+			proxy.Reload()
+
+			assert.Equal(t, ReloadMsg{PreserveState: tt.expect}, sub.drain()[0].Data)
+		})
+	}
+}
+
 func TestProxy_proxyHandler_GzipHTML(t *testing.T) {
 	body := "<body><h1>gzip</h1></body>"
 
@@ -745,3 +866,224 @@ func TestProxy_appStartTimeout(t *testing.T) {
 		})
 	}
 }
+
+func TestProxy_matchRoute(t *testing.T) {
+	t.Parallel()
+
+	proxy := NewProxy(&cfgProxy{
+		AppPort: 8080,
+		Routes: []cfgProxyRoute{
+			{Path: "/api", Upstream: "localhost:9000"},
+			{Path: "/api/app", Upstream: ""},
+			{Path: "/assets/", Upstream: "localhost:5173"},
+		},
+	})
+
+	tests := []struct {
+		path     string
+		upstream string
+		app      bool
+	}{
+		{path: "/", upstream: "localhost:8080", app: true},
+		{path: "/api", upstream: "localhost:9000"},
+		{path: "/api/users", upstream: "localhost:9000"},
+		{path: "/apix", upstream: "localhost:8080", app: true},
+		{path: "/api/app/x", upstream: "localhost:8080", app: true},
+		{path: "/assets/app.js", upstream: "localhost:5173"},
+	}
+	for _, tt := range tests {
+		route := proxy.matchRoute("localhost:8090", tt.path)
+		assert.Equal(t, tt.upstream, route.upstream, tt.path)
+		assert.Equal(t, tt.app, route.app, tt.path)
+	}
+}
+
+func TestProxy_proxyHandler_Routes(t *testing.T) {
+	var gotPath string
+	frontend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<body>frontend</body>")
+	}))
+	defer frontend.Close()
+
+	proxy := NewProxy(&cfgProxy{
+		Enabled:   true,
+		ProxyPort: proxyPort,
+		AppPort:   2222,
+		Routes: []cfgProxyRoute{
+			{Path: "/ui", StripPrefix: true, Upstream: fmt.Sprintf("localhost:%d", getServerPort(t, frontend))},
+			{Path: "/inject", Upstream: fmt.Sprintf("localhost:%d", getServerPort(t, frontend)), Inject: true},
+		},
+	})
+
+	t.Run("strip_prefix_and_no_injection", func(t *testing.T) {
+		req := httptest.NewRequest("GET", fmt.Sprintf("http://localhost:%d/ui/page?x=1", proxyPort), nil)
+		rec := httptest.NewRecorder()
+		proxy.proxyHandler(rec, req)
+
+		assert.Equal(t, "/page", gotPath)
+		assert.Equal(t, "<body>frontend</body>", rec.Body.String())
+	})
+
+	t.Run("opt_in_injection", func(t *testing.T) {
+		req := httptest.NewRequest("GET", fmt.Sprintf("http://localhost:%d/inject/page", proxyPort), nil)
+		rec := httptest.NewRecorder()
+		proxy.proxyHandler(rec, req)
+
+		assert.Equal(t, "/inject/page", gotPath)
+		assert.Contains(t, rec.Body.String(), ProxyScript)
+	})
+
+	t.Run("unreachable_upstream_is_not_retried", func(t *testing.T) {
+		down := NewProxy(&cfgProxy{
+			AppPort:         2222,
+			AppStartTimeout: 10000,
+			Routes:          []cfgProxyRoute{{Path: "/ui", Upstream: "localhost:1"}},
+		})
+		req := httptest.NewRequest("GET", fmt.Sprintf("http://localhost:%d/ui", proxyPort), nil)
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		down.proxyHandler(rec, req)
+
+		assert.Less(t, time.Since(start), 5*time.Second)
+		assert.Equal(t, http.StatusBadGateway, rec.Code)
+	})
+}
+
+func TestProxy_matchRoute_Hosts(t *testing.T) {
+	t.Parallel()
+
+	proxy := NewProxy(&cfgProxy{
+		AppPort: 8080,
+		Routes: []cfgProxyRoute{
+			{Host: "*.app.localhost", Path: "/", Upstream: "localhost:9001"},
+			{Host: "admin.app.localhost", Path: "/", Upstream: "localhost:9002"},
+			{Host: "*.app.localhost", Path: "/api", Upstream: "localhost:9003"},
+			{Path: "/api", Upstream: "localhost:9004"},
+		},
+	})
+
+	tests := []struct {
+		host     string
+		path     string
+		upstream string
+	}{
+		{host: "tenant-a.app.localhost:8090", path: "/", upstream: "localhost:9001"},
+		{host: "a.b.app.localhost", path: "/", upstream: "localhost:9001"},
+		{host: "ADMIN.app.localhost:8090", path: "/api", upstream: "localhost:9002"},
+		{host: "tenant-a.app.localhost:8090", path: "/api/x", upstream: "localhost:9003"},
+		{host: "app.localhost:8090", path: "/", upstream: "localhost:8080"},
+		{host: "localhost:8090", path: "/api", upstream: "localhost:9004"},
+	}
+	for _, tt := range tests {
+		route := proxy.matchRoute(tt.host, tt.path)
+		assert.Equal(t, tt.upstream, route.upstream, "%s%s", tt.host, tt.path)
+	}
+}
+
+func TestProxy_proxyHandler_HostHeader(t *testing.T) {
+	hostCh := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		hostCh <- r.Host
+	}))
+	defer srv.Close()
+	upstream := fmt.Sprintf("localhost:%d", getServerPort(t, srv))
+
+	proxy := NewProxy(&cfgProxy{
+		AppPort: 2222,
+		Routes: []cfgProxyRoute{
+			{Host: "*.app.localhost", Path: "/", Upstream: upstream, PreserveHost: true},
+			{Host: "admin.app.localhost", Path: "/", Upstream: upstream, HostHeader: "admin.internal"},
+			{Host: "api.localhost", Path: "/", Upstream: upstream},
+		},
+	})
+
+	tests := []struct {
+		host   string
+		expect string
+	}{
+		{host: "tenant-a.app.localhost:8090", expect: "tenant-a.app.localhost:8090"},
+		{host: "admin.app.localhost:8090", expect: "admin.internal"},
+		{host: "api.localhost:8090", expect: upstream},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "http://"+tt.host+"/", nil)
+		proxy.proxyHandler(httptest.NewRecorder(), req)
+		assert.Equal(t, tt.expect, <-hostCh, tt.host)
+	}
+}
+
+func TestProxy_URL(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		listen string
+		expect string
+	}{
+		{listen: "", expect: "http://localhost:1111"},
+		{listen: "0.0.0.0:8090", expect: "http://localhost:8090"},
+		{listen: ":8090", expect: "http://localhost:8090"},
+		{listen: "[::1]:8090", expect: "http://[::1]:8090"},
+		{listen: "unix:/tmp/air.sock", expect: "unix:/tmp/air.sock"},
+	}
+	for _, tt := range tests {
+		proxy := NewProxy(&cfgProxy{ProxyPort: 1111, Listen: tt.listen})
+		assert.Equal(t, tt.expect, proxy.URL(), tt.listen)
+	}
+}
+
+func TestProxy_listenUnix(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "air.sock")
+	proxy := NewProxy(&cfgProxy{Listen: "unix:" + socket})
+
+	l, err := proxy.listen()
+	require.NoError(t, err)
+	// leave the socket behind, as a crashed run would
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	_, err = os.Stat(socket)
+	require.NoError(t, err)
+
+	l, err = proxy.listen()
+	require.NoError(t, err)
+	l.Close()
+}
+
+func TestProxy_proxyHandler_Upstream(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "app %s", r.URL.Path)
+	})
+
+	t.Run("unix", func(t *testing.T) {
+		socket := filepath.Join(t.TempDir(), "app.sock")
+		l, err := net.Listen("unix", socket)
+		require.NoError(t, err)
+		srv := &httptest.Server{Listener: l, Config: &http.Server{Handler: handler}}
+		srv.Start()
+		defer srv.Close()
+
+		proxy := NewProxy(&cfgProxy{ProxyPort: proxyPort, Upstream: "unix:" + socket})
+		rec := httptest.NewRecorder()
+		proxy.proxyHandler(rec, httptest.NewRequest("GET", "/users", nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "app /users", rec.Body.String())
+	})
+
+	t.Run("https", func(t *testing.T) {
+		srv := httptest.NewTLSServer(handler)
+		defer srv.Close()
+
+		proxy := NewProxy(&cfgProxy{ProxyPort: proxyPort, Upstream: srv.URL, UpstreamInsecure: true, AppStartTimeout: 200})
+		rec := httptest.NewRecorder()
+		proxy.proxyHandler(rec, httptest.NewRequest("GET", "/users", nil))
+		assert.Equal(t, "app /users", rec.Body.String())
+
+		// the self-signed certificate is rejected unless verification is off
+		proxy = NewProxy(&cfgProxy{ProxyPort: proxyPort, Upstream: srv.URL, AppStartTimeout: 200})
+		rec = httptest.NewRecorder()
+		proxy.proxyHandler(rec, httptest.NewRequest("GET", "/users", nil))
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+}
@@ -0,0 +1,182 @@
+package runner
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// A watcher whose event queue overflowed has dropped events, so the changes
+// made meanwhile are found again by rescanning the watched dirs. Only files
+// modified since the last sync, and since their last dispatched event, are
+// read, and those with a cached checksum are reported when their contents
+// differ. The events found go through the dispatcher like the watcher's.
+
+// mtimeSlack is how much older than the last sync a file may look and still
+// be checked for changes.
+const mtimeSlack = time.Second
+
+// scheduleRescan queues a rescan. Overflows during a rescan queue one more.
+func (e *Engine) scheduleRescan() {
+	select {
+	case e.rescanCh <- struct{}{}:
+	default:
+		// a rescan is already queued
+	}
+}
+
+func (e *Engine) rescanLoop() {
+	for {
+		select {
+		case <-e.watcherStopCh:
+			return
+		case <-e.rescanCh:
+			e.rescan()
+		}
+	}
+}
+
+// rescan dispatches the events missed since the last sync.
+func (e *Engine) rescan() {
+	for _, ev := range e.missedEvents() {
+		select {
+		case e.missedCh <- ev:
+		case <-e.watcherStopCh:
+			return
+		}
+	}
+}
+
+// missedEvents synthesizes the events missed since the last sync.
+func (e *Engine) missedEvents() []fsnotify.Event {
+	start := time.Now()
+	var since time.Time
+	e.withLock(func() {
+		since, e.syncedAt = e.syncedAt, start
+	})
+
+	var missed []fsnotify.Event
+	visit := func(path string, info os.FileInfo) {
+		if ev, ok := e.missedEvent(path, info, since); ok {
+			missed = append(missed, ev)
+		}
+	}
+	paths := e.watchedPaths()
+	for _, path := range paths {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			info, err := os.Stat(path)
+			if err != nil {
+				e.forgetWatched(path)
+			} else if !info.IsDir() {
+				// an include_file
+				visit(path, info)
+			}
+			continue
+		}
+		for _, entry := range entries {
+			name := filepath.Join(path, entry.Name())
+			if entry.IsDir() {
+				if !e.isWatched(name) {
+					// dirs created meanwhile are registered, and their files
+					// reported as created
+					r := e.newRegistration()
+					r.onFile = visit
+					if err := e.watchTree(r, name); err != nil {
+						e.watcherLog("failed to watch %s, error: %s", name, err.Error())
+					}
+				}
+				continue
+			}
+			if info, err := entry.Info(); err == nil {
+				visit(name, info)
+			}
+		}
+	}
+	for _, name := range e.fileChecksums.names() {
+		if _, err := os.Stat(name); errors.Is(err, os.ErrNotExist) {
+			e.fileChecksums.remove(name)
+			missed = append(missed, fsnotify.Event{Name: name, Op: fsnotify.Remove})
+		}
+	}
+
+	e.watcherLog("rescanned %d watched paths in %s, %d files changed", len(paths), time.Since(start).Round(time.Millisecond), len(missed))
+	return missed
+}
+
+// missedEvent returns the event of a file changed since the given time.
+func (e *Engine) missedEvent(path string, info os.FileInfo, since time.Time) (fsnotify.Event, bool) {
+	// file systems stamp mtimes with a coarse clock, which may lag behind
+	if !info.Mode().IsRegular() || info.ModTime().Before(since.Add(-mtimeSlack)) {
+		return fsnotify.Event{}, false
+	}
+	if e.wasDispatched(path, info) {
+		return fsnotify.Event{}, false
+	}
+	cached, ok := e.fileChecksums.get(path)
+	if !ok {
+		return fsnotify.Event{Name: path, Op: fsnotify.Create}, true
+	}
+	checksum, err := e.checksumFunc(path)(path)
+	if err == nil && checksum == cached {
+		return fsnotify.Event{}, false
+	}
+	// the cache is left to isModified, which decides on the rebuild
+	return fsnotify.Event{Name: path, Op: fsnotify.Write}, true
+}
+
+// stampDispatched records the stat of a file whose event is dispatched.
+func (e *Engine) stampDispatched(path string) {
+	info, err := os.Stat(path)
+	e.withLock(func() {
+		if err != nil {
+			delete(e.dispatched, path)
+			return
+		}
+		e.dispatched[path] = stampOf(info)
+	})
+}
+
+// wasDispatched reports whether the last event dispatched for path saw it
+// as it is now.
+func (e *Engine) wasDispatched(path string, info os.FileInfo) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	stamp, ok := e.dispatched[path]
+	return ok && stamp == stampOf(info)
+}
+
+func (e *Engine) addWatched(path string) {
+	e.withLock(func() {
+		e.watched[filepath.Clean(path)] = struct{}{}
+	})
+}
+
+func (e *Engine) forgetWatched(path string) {
+	e.withLock(func() {
+		delete(e.watched, filepath.Clean(path))
+	})
+}
+
+func (e *Engine) isWatched(path string) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	_, ok := e.watched[filepath.Clean(path)]
+	return ok
+}
+
+// watchedPaths returns the watched dirs and files, sorted.
+func (e *Engine) watchedPaths() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	paths := make([]string, 0, len(e.watched))
+	for path := range e.watched {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	return paths
+}
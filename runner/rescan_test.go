@@ -0,0 +1,108 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRescanSynthesizesMissedEvents(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"main.go":       "package main",
+		"api/api.go":    "package api",
+		"api/touch.go":  "package api",
+		"api/gone.go":   "package api",
+		"vendor/x/x.go": "package x",
+	})
+	cfg := defaultConfig()
+	cfg.Root = root
+	cfg.Log.Silent = true
+	cfg.Build.ExcludeUnchanged = true
+	cfg.Build.ExcludeDir = []string{"vendor"}
+	require.NoError(t, cfg.preprocess(nil))
+	e, err := NewEngineWithConfig(&cfg, false)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = e.watcher.Close() })
+	require.NoError(t, e.watchConfiguredDirs())
+
+	// changes made while the watcher's queue overflowed
+	abs := func(rel string) string { return filepath.Join(root, filepath.FromSlash(rel)) }
+	writeFiles(t, root, map[string]string{
+		"api/api.go":    "package api // changed",
+		"api/touch.go":  "package api",
+		"web/new.go":    "package web",
+		"vendor/y/y.go": "package y",
+	})
+	require.NoError(t, os.Remove(abs("api/gone.go")))
+
+	var got []string
+	for _, ev := range e.missedEvents() {
+		got = append(got, ev.Name)
+	}
+	assert.ElementsMatch(t, []string{abs("api/api.go"), abs("web/new.go"), abs("api/gone.go")}, got)
+	assert.True(t, e.isWatched(abs("web")))
+	assert.False(t, e.isWatched(abs("vendor/y")))
+
+	// the build checks the reported files, which updates their checksums
+	for _, name := range got {
+		e.isModified(name)
+	}
+	// nothing changed since
+	assert.Empty(t, e.missedEvents())
+}
+
+func TestRescanSkipsDispatchedChanges(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"main.go":    "package main",
+		"api/api.go": "package api",
+	})
+	abs := func(rel string) string { return filepath.Join(root, filepath.FromSlash(rel)) }
+	// the files predate the session
+	old := time.Now().Add(-time.Hour)
+	for _, rel := range []string{"main.go", "api/api.go"} {
+		require.NoError(t, os.Chtimes(abs(rel), old, old))
+	}
+	cfg := defaultConfig()
+	cfg.Root = root
+	cfg.Log.Silent = true
+	require.NoError(t, cfg.preprocess(nil))
+	e, err := NewEngineWithConfig(&cfg, false)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = e.watcher.Close() })
+	require.NoError(t, e.watchConfiguredDirs())
+
+	e.startDispatcher()
+	t.Cleanup(func() {
+		close(e.watcherStopCh)
+		e.dispatcher.Wait()
+	})
+	drain := func() []string {
+		var got []string
+		for {
+			select {
+			case ev := <-e.eventCh:
+				got = append(got, ev.Name)
+			case <-time.After(500 * time.Millisecond):
+				return got
+			}
+		}
+	}
+
+	// an edit handled as usual, before the queue overflows
+	writeFiles(t, root, map[string]string{"main.go": "package main // changed"})
+	require.Contains(t, drain(), abs("main.go"))
+
+	// an edit whose event was lost in the overflow
+	writeFiles(t, root, map[string]string{"api/api.go": "package api // changed"})
+	e.scheduleRescan()
+	got := drain()
+	// the watcher may also report the lost edit, but never the handled one
+	assert.Contains(t, got, abs("api/api.go"))
+	assert.NotContains(t, got, abs("main.go"))
+}
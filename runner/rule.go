@@ -11,15 +11,22 @@ import (
 // Rules are checked before the main build filters, so a file matched by a
 // rule never triggers a rebuild.
 func (e *Engine) matchRuleIndex(path string) int {
+	idx, _ := e.matchRule(path)
+	return idx
+}
+
+// matchRule returns the index of the first rule matching path, or -1, and
+// the matcher of the rule that matched it, for debug logs.
+func (e *Engine) matchRule(path string) (int, string) {
 	for i := range e.config.Build.Rules {
-		if e.ruleMatches(&e.config.Build.Rules[i], path) {
-			return i
+		if by, ok := e.ruleMatches(&e.config.Build.Rules[i], path); ok {
+			return i, by
 		}
 	}
-	return -1
+	return -1, ""
 }
 
-func (e *Engine) ruleMatches(r *cfgRule, path string) bool {
+func (e *Engine) ruleMatches(r *cfgRule, path string) (string, bool) {
 	if len(r.includeDirAbs) > 0 {
 		inDir := false
 		cleaned := filepath.Clean(path)
@@ -30,31 +37,42 @@ func (e *Engine) ruleMatches(r *cfgRule, path string) bool {
 			}
 		}
 		if !inDir {
-			return false
+			return "", false
 		}
 	}
 	for _, re := range r.regexCompiled {
 		if re.MatchString(path) {
-			return false
+			return "", false
 		}
 	}
-	if len(r.IncludeExt) == 0 && len(r.IncludeFile) == 0 {
-		return true
+	rel := e.globPath(path)
+	if _, ok := r.exclude.match(rel); ok {
+		return "", false
+	}
+	if len(r.IncludeExt) == 0 && len(r.IncludeFile) == 0 && len(r.Include) == 0 {
+		return "include_dir", true
+	}
+	// a negated include pattern overrides include_ext and include_file
+	by, included := r.include.match(rel)
+	if by != "" && !included {
+		return "", false
 	}
 	ext := filepath.Ext(path)
 	for _, v := range r.IncludeExt {
 		v = strings.TrimSpace(v)
 		if v == extWildcard || ext == "."+v {
-			return true
+			return fmt.Sprintf("include_ext %q", v), true
 		}
 	}
-	rel := filepath.ToSlash(cleanPath(e.config.rel(path)))
 	for _, f := range r.IncludeFile {
 		if filepath.ToSlash(f) == rel {
-			return true
+			return fmt.Sprintf("include_file %q", f), true
 		}
 	}
-	return false
+	if included {
+		return fmt.Sprintf("include %q", by), true
+	}
+	return "", false
 }
 
 // isRuleDir reports whether dir is exactly a rule's include_dir. Such dirs
@@ -90,7 +108,8 @@ func (e *Engine) ruleLog(name string, format string, v ...interface{}) {
 
 // runRule consumes change events for one rule, debounces them, and runs the
 // rule's cmd. The cmd runs to completion; events arriving meanwhile stay
-// queued and trigger another run afterwards.
+// queued and trigger another run afterwards. Hot swap rules then tell the
+// browser which assets changed.
 func (e *Engine) runRule(idx int) {
 	rule := &e.config.Build.Rules[idx]
 	ch := e.ruleEventChs[idx]
@@ -98,21 +117,42 @@ func (e *Engine) runRule(idx int) {
 		select {
 		case <-e.exitCh:
 			return
-		case filename := <-ch:
+		case ev := <-ch:
 			time.Sleep(rule.delay())
 			// coalesce the burst of events into a single run
+			changes := &changeSet{}
+			changes.add(e.config.rel(ev.Name), ev.Op)
 			for drained := false; !drained; {
 				select {
-				case <-ch:
+				case ev := <-ch:
+					changes.add(e.config.rel(ev.Name), ev.Op)
 				default:
 					drained = true
 				}
 			}
-			e.ruleLog(rule.Name, "%s has changed", e.config.rel(filename))
-			e.ruleLog(rule.Name, "> %s", rule.Cmd)
-			if err := e.runCommand(rule.Cmd); err != nil {
-				e.ruleLog(rule.Name, "failed to execute cmd: %s", err.Error())
+			e.ruleLog(rule.Name, "%s", changes.summary())
+			if rule.Cmd != "" {
+				e.ruleLog(rule.Name, "> %s", rule.Cmd)
+				env := e.changesEnv(changes, fmt.Sprintf("changed-files-rule%d.json", idx))
+				if err := e.runCommand(rule.Cmd, env...); err != nil {
+					e.ruleLog(rule.Name, "failed to execute cmd: %s", err.Error())
+					continue
+				}
+			}
+			if rule.HotSwap && e.config.Proxy.Enabled {
+				e.swapAssets(changes.paths())
 			}
 		}
 	}
 }
+
+// swapAssets sends the changed files, relative to the root, to the browser
+// as slash-separated paths for an in-place swap.
+func (e *Engine) swapAssets(files []string) {
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		paths = append(paths, filepath.ToSlash(f))
+	}
+	e.mainDebug("swapping assets %v", paths)
+	e.proxy.AssetsChanged(paths)
+}
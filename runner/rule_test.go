@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -32,6 +33,11 @@ func TestNormalizeRules(t *testing.T) {
 		assert.Contains(t, err.Error(), "cmd is required")
 	})
 
+	t.Run("cmd is optional for hot swap rules", func(t *testing.T) {
+		b := cfgBuild{Rules: []cfgRule{{Name: "css", IncludeExt: []string{"css"}, HotSwap: true}}}
+		require.NoError(t, b.normalizeRules(root))
+	})
+
 	t.Run("at least one matcher is required", func(t *testing.T) {
 		b := cfgBuild{Rules: []cfgRule{{Cmd: "true"}}}
 		err := b.normalizeRules(root)
@@ -109,6 +115,39 @@ func TestRuleMatches(t *testing.T) {
 	assert.False(t, e.inRuleDir(filepath.Join(root, "cmd")))
 }
 
+func TestRuleMatchesGlobs(t *testing.T) {
+	root := t.TempDir()
+	cfg := defaultConfig()
+	cfg.Root = root
+	cfg.Build.Rules = []cfgRule{{
+		Name:    "templ",
+		Cmd:     "templ generate",
+		Include: []string{"**/*.templ", "web/**/*.css"},
+		Exclude: []string{"**/vendor/**", "!web/vendor/keep.templ"},
+	}}
+	require.NoError(t, cfg.Build.normalizeRules(root))
+	e := &Engine{config: &cfg}
+
+	tests := []struct {
+		path string
+		by   string
+	}{
+		{path: "views/home.templ", by: `include "**/*.templ"`},
+		{path: "web/css/app.css", by: `include "web/**/*.css"`},
+		{path: "app.css"},
+		{path: "web/vendor/lib.templ"},
+		{path: "web/vendor/keep.templ", by: `include "**/*.templ"`},
+	}
+	for _, tt := range tests {
+		idx, by := e.matchRule(filepath.Join(root, filepath.FromSlash(tt.path)))
+		assert.Equal(t, tt.by != "", idx >= 0, "path: %s", tt.path)
+		assert.Equal(t, tt.by, by, "path: %s", tt.path)
+	}
+
+	b := cfgBuild{Rules: []cfgRule{{Cmd: "true", Include: []string{"[a-"}}}}
+	require.Error(t, b.normalizeRules(root))
+}
+
 // TestRuleRunsCmdWithoutRebuild verifies the core behavior of issue #540: a
 // change in a rule's directory runs the rule cmd and does not rebuild the app,
 // even when that directory is listed in the main build's exclude_dir.
@@ -132,7 +171,7 @@ exclude_dir = ["web"]
 name = "assets"
 include_dir = ["web"]
 include_ext = ["js"]
-cmd = "echo asset >> asset_builds.txt"
+cmd = "echo $AIR_CHANGED_FILES >> asset_builds.txt"
 delay = 100
 `
 	require.NoError(t, os.WriteFile(dftTOML, []byte(config), 0o644))
@@ -163,5 +202,48 @@ delay = 100
 	time.Sleep(2 * time.Second)
 
 	assert.Equal(t, 1, countLines("asset_builds.txt"), "rule cmd should have run once")
+	assets, err := os.ReadFile("asset_builds.txt")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("web", "app.js")+"\n", string(assets), "rule cmd should get the changed files")
 	assert.Equal(t, 1, countLines("builds.txt"), "changing a rule file must not rebuild the app")
 }
+
+type assetRecorder struct {
+	reloader
+	assetsCh chan AssetsChangedMsg
+}
+
+func (r *assetRecorder) AssetsChanged(msg AssetsChangedMsg) {
+	r.assetsCh <- msg
+}
+
+func TestRuleHotSwapSendsChangedAssets(t *testing.T) {
+	root := t.TempDir()
+	cfg := defaultConfig()
+	cfg.Root = root
+	cfg.Proxy.Enabled = true
+	cfg.Build.Rules = []cfgRule{{Name: "css", IncludeExt: []string{"css"}, HotSwap: true, Delay: 10}}
+	require.NoError(t, cfg.Build.normalizeRules(root))
+
+	recorder := &assetRecorder{assetsCh: make(chan AssetsChangedMsg, 1)}
+	e := &Engine{
+		config:       &cfg,
+		logger:       newLogger(&cfg),
+		proxy:        &Proxy{config: &cfg.Proxy, stream: recorder},
+		ruleEventChs: []chan fsnotify.Event{make(chan fsnotify.Event, 10)},
+		exitCh:       make(chan bool),
+	}
+	defer close(e.exitCh)
+
+	e.ruleEventChs[0] <- fsnotify.Event{Name: filepath.Join(root, "web", "app.css"), Op: fsnotify.Write}
+	e.ruleEventChs[0] <- fsnotify.Event{Name: filepath.Join(root, "web", "app.css"), Op: fsnotify.Write}
+	e.ruleEventChs[0] <- fsnotify.Event{Name: filepath.Join(root, "web", "theme.css"), Op: fsnotify.Write}
+	go e.runRule(0)
+
+	select {
+	case msg := <-recorder.assetsCh:
+		assert.Equal(t, []string{"web/app.css", "web/theme.css"}, msg.Paths)
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected an assets-changed message")
+	}
+}
@@ -0,0 +1,198 @@
+package runner
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// semanticChecksum is fileChecksum for a Go file's program rather than its
+// text: comments other than directives, formatting and the order of imports
+// don't change it. Files which don't parse, and cgo files whose preamble is a
+// comment, are hashed as is.
+func semanticChecksum(filename string) (string, error) {
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	if len(contents) == 0 {
+		return "", errEmptyFile
+	}
+	normalized, ok := normalizeGo(filename, contents)
+	if !ok {
+		normalized = contents
+	}
+	sum := sha256.Sum256(normalized)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// normalizeGo returns the canonical form of a Go file, or false if it can't
+// be normalized.
+func normalizeGo(filename string, src []byte) ([]byte, bool) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil, false
+	}
+	var buf bytes.Buffer
+
+	// directives, with the declaration or spec they apply to
+	for _, group := range f.Comments {
+		for _, c := range group.List {
+			if isDirective(c.Text) {
+				fmt.Fprintf(&buf, "%s: %s\n", directiveTarget(f, c.Pos()), c.Text)
+			}
+		}
+	}
+
+	// imports in any order and grouping are the same, as packages are
+	// initialized by import path
+	var imports []string
+	decls := f.Decls[:0:0]
+	for _, d := range f.Decls {
+		if !isImportDecl(d) {
+			decls = append(decls, d)
+			continue
+		}
+		for _, spec := range d.(*ast.GenDecl).Specs {
+			imp := spec.(*ast.ImportSpec)
+			path, _ := strconv.Unquote(imp.Path.Value)
+			if path == "C" {
+				return nil, false
+			}
+			name := ""
+			if imp.Name != nil {
+				name = imp.Name.Name
+			}
+			imports = append(imports, name+" "+path)
+		}
+	}
+	slices.Sort(imports)
+	for _, imp := range imports {
+		fmt.Fprintf(&buf, "import %s\n", imp)
+	}
+
+	// with all positions the same, the printer lays the code out the same
+	// whatever its original formatting
+	f.Decls = decls
+	f.Imports = nil
+	f.Comments = nil
+	ast.Inspect(f, func(n ast.Node) bool {
+		if n != nil {
+			clearLayout(reflect.ValueOf(n))
+		}
+		return true
+	})
+	if err := printer.Fprint(&buf, token.NewFileSet(), f); err != nil {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// isDirective reports whether a comment changes how the file is built:
+// //go: directives and build constraints, and //line directives.
+func isDirective(text string) bool {
+	return strings.HasPrefix(text, "//go:") ||
+		strings.HasPrefix(text, "// +build") ||
+		strings.HasPrefix(text, "//line ")
+}
+
+// directiveTarget names the node following the directive at pos: the
+// package clause, a func, a declaration or a spec inside a grouped one.
+func directiveTarget(f *ast.File, pos token.Pos) string {
+	if pos < f.Package {
+		return "package"
+	}
+	for _, d := range f.Decls {
+		if d.End() < pos {
+			continue
+		}
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			name := "func " + d.Name.Name
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				name = "func (" + types.ExprString(d.Recv.List[0].Type) + ") " + d.Name.Name
+			}
+			if d.Pos() < pos {
+				return "in " + name
+			}
+			return name
+		case *ast.GenDecl:
+			group := d.Tok.String()
+			if len(d.Specs) > 0 {
+				group += " " + specName(d.Specs[0])
+			}
+			if d.Pos() > pos {
+				return group
+			}
+			for _, spec := range d.Specs {
+				if spec.Pos() > pos {
+					return group + " > " + specName(spec)
+				}
+			}
+			return group + " > end"
+		}
+	}
+	return "end"
+}
+
+func specName(spec ast.Spec) string {
+	switch spec := spec.(type) {
+	case *ast.ImportSpec:
+		return spec.Path.Value
+	case *ast.TypeSpec:
+		return spec.Name.Name
+	case *ast.ValueSpec:
+		names := make([]string, len(spec.Names))
+		for i, name := range spec.Names {
+			names[i] = name.Name
+		}
+		return strings.Join(names, ",")
+	}
+	return ""
+}
+
+func isImportDecl(d ast.Decl) bool {
+	gen, ok := d.(*ast.GenDecl)
+	return ok && gen.Tok == token.IMPORT
+}
+
+var (
+	posType     = reflect.TypeFor[token.Pos]()
+	commentType = reflect.TypeFor[*ast.CommentGroup]()
+)
+
+// clearLayout sets the valid positions of the node v points to to the same
+// one, and drops its comments, which the printer prints without the file's
+// comments. Positions stay valid, as some tell the syntax: the ellipsis of a
+// variadic call, or the = of an alias.
+func clearLayout(v reflect.Value) {
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return
+	}
+	v = v.Elem()
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	for i := range v.NumField() {
+		field := v.Field(i)
+		switch {
+		case !field.CanSet():
+		case field.Type() == posType && field.Int() != int64(token.NoPos):
+			field.SetInt(1)
+		case field.Type() == commentType:
+			field.SetZero()
+		}
+	}
+}
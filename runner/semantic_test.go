@@ -0,0 +1,211 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSemanticChecksum(t *testing.T) {
+	t.Parallel()
+
+	const base = `//go:build linux
+
+package app
+
+import (
+	"fmt"
+	"os"
+)
+
+type ID = int
+
+//go:noinline
+func run(args ...string) {
+	fmt.Println(os.Args, args)
+	print(args...)
+}
+
+func print(args ...string) {}
+`
+	tests := []struct {
+		name string
+		src  string
+		same bool
+	}{
+		{
+			name: "comments",
+			src: `//go:build linux
+
+// Package app runs.
+package app
+
+import (
+	"fmt"
+	"os"
+)
+
+// ID identifies.
+type ID = int
+
+//go:noinline
+func run(args ...string) {
+	fmt.Println(os.Args, args) // print them
+	/* and again */ print(args...)
+}
+
+func print(args ...string) {}
+`,
+			same: true,
+		},
+		{
+			name: "formatting and import order",
+			src: `//go:build linux
+package app
+import "os"
+import "fmt"
+type ID = int
+//go:noinline
+func run(args ...string) { fmt.Println(os.Args,
+	args); print(args...) }
+func print(args ...string) {
+}
+`,
+			same: true,
+		},
+		{
+			name: "code",
+			src:  strings.Replace(base, "fmt.Println(os.Args, args)", "fmt.Println(args, os.Args)", 1),
+		},
+		{
+			name: "build constraint",
+			src:  strings.Replace(base, "//go:build linux", "//go:build darwin", 1),
+		},
+		{
+			name: "directive",
+			src:  strings.Replace(base, "//go:noinline\n", "", 1),
+		},
+		{
+			name: "alias",
+			src:  strings.Replace(base, "type ID = int", "type ID int", 1),
+		},
+		{
+			name: "variadic call",
+			src:  strings.Replace(base, "print(args...)", "print(args[0])", 1),
+		},
+		{
+			name: "import name",
+			src:  strings.Replace(base, `"os"`, `sys "os"`, 1),
+		},
+	}
+
+	dir := t.TempDir()
+	write := func(name, src string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(src), 0o644))
+		return path
+	}
+	want, err := semanticChecksum(write("base.go", base))
+	require.NoError(t, err)
+	for _, tt := range tests {
+		require.NotEqual(t, base, tt.src, tt.name)
+		got, err := semanticChecksum(write(tt.name+".go", tt.src))
+		require.NoError(t, err)
+		if tt.same {
+			assert.Equal(t, want, got, tt.name)
+		} else {
+			assert.NotEqual(t, want, got, tt.name)
+		}
+	}
+}
+
+func TestSemanticChecksumHashesAsIs(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string][2]string{
+		"syntax error": {"package app\nfunc (", "package app\n\nfunc ("},
+		"cgo preamble": {
+			"package app\n\n// #include <stdio.h>\nimport \"C\"\n",
+			"package app\n\n// #include <stdlib.h>\nimport \"C\"\n",
+		},
+	}
+	dir := t.TempDir()
+	for name, srcs := range tests {
+		var sums []string
+		for i, src := range srcs {
+			path := filepath.Join(dir, name+string(rune('a'+i))+".go")
+			require.NoError(t, os.WriteFile(path, []byte(src), 0o644))
+			sum, err := semanticChecksum(path)
+			require.NoError(t, err)
+			raw, err := fileChecksum(path)
+			require.NoError(t, err)
+			assert.Equal(t, raw, sum, name)
+			sums = append(sums, sum)
+		}
+		assert.NotEqual(t, sums[0], sums[1], name)
+	}
+
+	_, err := semanticChecksum(filepath.Join(dir, "missing.go"))
+	require.Error(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "empty.go"), nil, 0o644))
+	_, err = semanticChecksum(filepath.Join(dir, "empty.go"))
+	require.ErrorIs(t, err, errEmptyFile)
+}
+
+func TestSemanticChecksumDirectiveMoved(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string][2]string{
+		"var group": {
+			"package app\n\nimport _ \"embed\"\n\nvar (\n\t//go:embed a.txt\n\ta string\n\tb string\n)\n",
+			"package app\n\nimport _ \"embed\"\n\nvar (\n\ta string\n\t//go:embed a.txt\n\tb string\n)\n",
+		},
+		"methods": {
+			"package app\n\ntype T struct{}\n\n//go:noinline\nfunc (T) a() {}\n\nfunc (T) b() {}\n",
+			"package app\n\ntype T struct{}\n\nfunc (T) a() {}\n\n//go:noinline\nfunc (T) b() {}\n",
+		},
+	}
+	dir := t.TempDir()
+	for name, srcs := range tests {
+		var sums []string
+		for i, src := range srcs {
+			path := filepath.Join(dir, name+string(rune('a'+i))+".go")
+			require.NoError(t, os.WriteFile(path, []byte(src), 0o644))
+			sum, err := semanticChecksum(path)
+			require.NoError(t, err)
+			sums = append(sums, sum)
+		}
+		assert.NotEqual(t, sums[0], sums[1], name)
+	}
+}
+
+func TestIsModifiedSemantic(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"main.go":    "package main\n\nfunc main() {}\n",
+		"config.txt": "a",
+	})
+	cfg := defaultConfig()
+	cfg.Root = root
+	cfg.Log.Silent = true
+	cfg.Build.ExcludeUnchanged = true
+	cfg.Build.SemanticChecksum = true
+	e := &Engine{config: &cfg, logger: newLogger(&cfg), fileChecksums: &checksumMap{m: make(map[string]string)}}
+
+	main := filepath.Join(root, "main.go")
+	assert.True(t, e.isModified(main))
+	writeFiles(t, root, map[string]string{"main.go": "// Command main.\npackage main\n\nfunc main() {\n}\n"})
+	assert.False(t, e.isModified(main), "comment and whitespace edits")
+	writeFiles(t, root, map[string]string{"main.go": "package main\n\nfunc main() { println() }\n"})
+	assert.True(t, e.isModified(main))
+
+	// other files keep plain checksums
+	config := filepath.Join(root, "config.txt")
+	assert.True(t, e.isModified(config))
+	writeFiles(t, root, map[string]string{"config.txt": "a "})
+	assert.True(t, e.isModified(config))
+}
@@ -78,6 +78,17 @@ func (e *Engine) watcherLog(format string, v ...interface{}) {
 	}
 }
 
+func (e *Engine) proxyLog(format string, v ...interface{}) {
+	if e.config.Log.Silent {
+		return
+	}
+	if e.debugMode || !e.config.Log.MainOnly {
+		e.logWithLock(func() {
+			e.logger.proxy()(format, v...)
+		})
+	}
+}
+
 func (e *Engine) watcherDebug(format string, v ...interface{}) {
 	if e.config.Log.Silent {
 		return
@@ -381,8 +392,13 @@ func fileChecksum(filename string) (checksum string, err error) {
 // that triggered the event may still be in flight. A file that stays empty for
 // the whole retry budget is treated as genuinely empty.
 func settledFileChecksum(filename string) (checksum string, err error) {
+	return settledChecksum(fileChecksum, filename)
+}
+
+// settledChecksum is settledFileChecksum with the given checksum function.
+func settledChecksum(sum func(string) (string, error), filename string) (checksum string, err error) {
 	for attempt := 0; ; attempt++ {
-		checksum, err = fileChecksum(filename)
+		checksum, err = sum(filename)
 		if !errors.Is(err, errEmptyFile) || attempt == emptyReadRetries {
 			return checksum, err
 		}
@@ -394,12 +410,67 @@ func settledFileChecksum(filename string) (checksum string, err error) {
 type checksumMap struct {
 	l sync.Mutex
 	m map[string]string
+	// stamps holds the mtime and size of the files when they were hashed,
+	// which are saved with the checksums for the next session.
+	stamps map[string]fileStamp
+}
+
+// fileStamp is the mtime and size of a file.
+type fileStamp struct {
+	modTime int64
+	size    int64
+}
+
+func stampOf(info os.FileInfo) fileStamp {
+	return fileStamp{modTime: info.ModTime().UnixNano(), size: info.Size()}
+}
+
+func (a *checksumMap) get(filename string) (string, bool) {
+	a.l.Lock()
+	defer a.l.Unlock()
+	checksum, ok := a.m[filename]
+	return checksum, ok
+}
+
+func (a *checksumMap) remove(filename string) {
+	a.l.Lock()
+	defer a.l.Unlock()
+	delete(a.m, filename)
+	delete(a.stamps, filename)
+}
+
+// names returns the files with a checksum.
+func (a *checksumMap) names() []string {
+	a.l.Lock()
+	defer a.l.Unlock()
+	names := make([]string, 0, len(a.m))
+	for name := range a.m {
+		names = append(names, name)
+	}
+	return names
 }
 
 // updateFileChecksum updates the filename with the given checksum if different.
 func (a *checksumMap) updateFileChecksum(filename, newChecksum string) (ok bool) {
 	a.l.Lock()
 	defer a.l.Unlock()
+	delete(a.stamps, filename)
+	return a.update(filename, newChecksum)
+}
+
+// store is updateFileChecksum for a checksum taken when the file had the
+// given stat.
+func (a *checksumMap) store(filename, newChecksum string, info os.FileInfo) (ok bool) {
+	a.l.Lock()
+	defer a.l.Unlock()
+	if a.stamps == nil {
+		a.stamps = make(map[string]fileStamp)
+	}
+	a.stamps[filename] = stampOf(info)
+	return a.update(filename, newChecksum)
+}
+
+func (a *checksumMap) update(filename, newChecksum string) bool {
 	oldChecksum, ok := a.m[filename]
 	if !ok || oldChecksum != newChecksum {
 		a.m[filename] = newChecksum
@@ -408,6 +479,17 @@ func (a *checksumMap) updateFileChecksum(filename, newChecksum string) (ok bool)
 	return false
 }
 
+// stamped returns the checksums with the stat of their files.
+func (a *checksumMap) stamped() map[string]savedChecksum {
+	a.l.Lock()
+	defer a.l.Unlock()
+	files := make(map[string]savedChecksum, len(a.stamps))
+	for name, stamp := range a.stamps {
+		files[name] = savedChecksum{Sum: a.m[name], ModTime: stamp.modTime, Size: stamp.size}
+	}
+	return files
+}
+
 // TomlInfo is a struct for toml config file
 type TomlInfo struct {
 	fieldPath  string
@@ -464,11 +546,16 @@ func setValue2Struct(v reflect.Value, fieldName string, value string) {
 			b, _ := strconv.ParseBool(value)
 			field.SetBool(b)
 		case reflect.Ptr:
-			if field.Type().Elem().Kind() != reflect.String {
+			v := reflect.New(field.Type().Elem())
+			switch field.Type().Elem().Kind() {
+			case reflect.String:
+				v.Elem().SetString(value)
+			case reflect.Bool:
+				b, _ := strconv.ParseBool(value)
+				v.Elem().SetBool(b)
+			default:
 				log.Fatalf("unsupported pointer type %s", field.Type().Elem().Kind())
 			}
-			v := reflect.New(field.Type().Elem())
-			v.Elem().SetString(value)
 			field.Set(v)
 		default:
 			log.Fatalf("unsupported type %s", v.FieldByName(fields[0]).Kind())
@@ -54,13 +54,18 @@ func (e *Engine) killCmd(cmd *exec.Cmd) (pid int, err error) {
 	}
 }
 
-func (e *Engine) startCmd(cmd string) (*exec.Cmd, io.ReadCloser, io.ReadCloser, error) {
+// startCmd starts cmd in a shell, with env added to the environment.
+func (e *Engine) startCmd(cmd string, env ...string) (*exec.Cmd, io.ReadCloser, io.ReadCloser, error) {
 	c := exec.Command("/bin/sh", "-c", cmd)
 	// because using pty cannot have same pgid
 	c.SysProcAttr = &syscall.SysProcAttr{
 		Setpgid: true,
 	}
 
+	if len(env) > 0 {
+		c.Env = append(os.Environ(), env...)
+	}
+
 	stderr, err := c.StderrPipe()
 	if err != nil {
 		return nil, nil, nil, err
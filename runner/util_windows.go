@@ -40,7 +40,8 @@ func (e *Engine) killCmd(cmd *exec.Cmd) (pid int, err error) {
 	return pid, err
 }
 
-func (e *Engine) startCmd(cmd string) (*exec.Cmd, io.ReadCloser, io.ReadCloser, error) {
+// startCmd starts cmd in a shell, with env added to the environment.
+func (e *Engine) startCmd(cmd string, env ...string) (*exec.Cmd, io.ReadCloser, io.ReadCloser, error) {
 	var err error
 
 	if !strings.Contains(cmd, ".exe") {
@@ -51,6 +52,10 @@ func (e *Engine) startCmd(cmd string) (*exec.Cmd, io.ReadCloser, io.ReadCloser,
 	// Use -NoProfile and -NonInteractive for better performance
 	c := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", cmd)
 
+	if len(env) > 0 {
+		c.Env = append(os.Environ(), env...)
+	}
+
 	stderr, err := c.StderrPipe()
 	if err != nil {
 		return nil, nil, nil, err
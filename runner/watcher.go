@@ -1,24 +1,155 @@
 package runner
 
 import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"sync"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/gohugoio/hugo/watcher/filenotify"
 )
 
+// errWatchLimit is returned by event watchers which can't watch more paths
+// because the system limit, fs.inotify.max_user_watches on Linux, is reached.
+var errWatchLimit = errors.New("too many watches, raise fs.inotify.max_user_watches")
+
 func newWatcher(cfg *Config) (filenotify.FileWatcher, error) {
-	if !cfg.Build.Poll {
-		return filenotify.NewEventWatcher()
+	if cfg.Build.Poll {
+		return filenotify.NewPollingWatcher(pollInterval(cfg.Build.PollInterval)), nil
+	}
+	native, err := newEventWatcher()
+	if err != nil {
+		return nil, err
 	}
+	// poll_dirs are polled from the start, and the dirs which don't fit in
+	// the system's watch limit once it is reached
+	poller := filenotify.NewPollingWatcher(pollInterval(cfg.Build.PollDirsInterval))
+	return newSplitWatcher(native, poller, slices.Clone(cfg.Build.pollDirsAbs)), nil
+}
+
+// pollInterval returns the poll interval of ms milliseconds, which is at
+// least 500ms.
+func pollInterval(ms int) time.Duration {
+	if ms < 500 {
+		ms = 500
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// splitWatcher polls the paths inside pollDirs and watches the others with
+// native events, feeding the events of both into the same channels.
+type splitWatcher struct {
+	native filenotify.FileWatcher
+	poller filenotify.FileWatcher
 
-	// Get the poll interval from the config.
-	interval := cfg.Build.PollInterval
+	mu       sync.RWMutex
+	pollDirs []string
 
-	// Make sure the interval is at least 500ms.
-	if interval < 500 {
-		interval = 500
+	events chan fsnotify.Event
+	errors chan error
+	done   chan struct{}
+	closed sync.Once
+	wg     sync.WaitGroup
+}
+
+func newSplitWatcher(native, poller filenotify.FileWatcher, pollDirs []string) *splitWatcher {
+	w := &splitWatcher{
+		native:   native,
+		poller:   poller,
+		pollDirs: pollDirs,
+		events:   make(chan fsnotify.Event),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
 	}
-	pollInterval := time.Duration(interval) * time.Millisecond
+	w.wg.Add(2)
+	go w.forward(native)
+	go w.forward(poller)
+	go func() {
+		w.wg.Wait()
+		close(w.events)
+		close(w.errors)
+	}()
+	return w
+}
+
+func (w *splitWatcher) forward(from filenotify.FileWatcher) {
+	defer w.wg.Done()
+	events, errs := from.Events(), from.Errors()
+	for events != nil || errs != nil {
+		select {
+		case <-w.done:
+			return
+		case ev, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			select {
+			case w.events <- ev:
+			case <-w.done:
+				return
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			select {
+			case w.errors <- err:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// poll polls dir and the paths inside it from now on.
+func (w *splitWatcher) poll(dir string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pollDirs = append(w.pollDirs, filepath.Clean(dir))
+}
+
+// polls reports whether name is polled.
+func (w *splitWatcher) polls(name string) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	cleaned := filepath.Clean(name)
+	for _, dir := range w.pollDirs {
+		if isSubPath(dir, cleaned) {
+			return true
+		}
+	}
+	return false
+}
+
+// watcherFor returns the watcher of name.
+func (w *splitWatcher) watcherFor(name string) filenotify.FileWatcher {
+	if w.polls(name) {
+		return w.poller
+	}
+	return w.native
+}
+
+func (w *splitWatcher) Events() <-chan fsnotify.Event { return w.events }
+
+func (w *splitWatcher) Errors() <-chan error { return w.errors }
+
+func (w *splitWatcher) Add(name string) error {
+	return w.watcherFor(name).Add(name)
+}
+
+func (w *splitWatcher) Remove(name string) error {
+	return w.watcherFor(name).Remove(name)
+}
 
-	return filenotify.NewPollingWatcher(pollInterval), nil
+func (w *splitWatcher) Close() error {
+	var err error
+	w.closed.Do(func() {
+		close(w.done)
+		err = errors.Join(w.native.Close(), w.poller.Close())
+	})
+	return err
 }
@@ -0,0 +1,198 @@
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gohugoio/hugo/watcher/filenotify"
+	"golang.org/x/sys/unix"
+)
+
+const inotifyMask = unix.IN_MODIFY | unix.IN_ATTRIB | unix.IN_CREATE | unix.IN_DELETE |
+	unix.IN_DELETE_SELF | unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_MOVE_SELF
+
+// inotifyWatcher is an inotify backend which, unlike the fsnotify one in
+// filenotify, reports what the kernel drops: a full event queue as
+// fsnotify.ErrEventOverflow, and a full max_user_watches as errWatchLimit.
+type inotifyWatcher struct {
+	file   *os.File
+	fd     int
+	events chan fsnotify.Event
+	errors chan error
+	done   chan struct{}
+	closed sync.Once
+
+	mu    sync.Mutex
+	paths map[int]string
+	wds   map[string]int
+}
+
+func newEventWatcher() (filenotify.FileWatcher, error) {
+	return newInotifyWatcher()
+}
+
+func newInotifyWatcher() (*inotifyWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify: %w", err)
+	}
+	w := &inotifyWatcher{
+		// a non-blocking fd is read through the runtime poller, so Close
+		// unblocks the pending read
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		fd:     fd,
+		events: make(chan fsnotify.Event),
+		errors: make(chan error),
+		done:   make(chan struct{}),
+		paths:  make(map[int]string),
+		wds:    make(map[string]int),
+	}
+	go w.readEvents()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Events() <-chan fsnotify.Event { return w.events }
+
+func (w *inotifyWatcher) Errors() <-chan error { return w.errors }
+
+func (w *inotifyWatcher) Add(name string) error {
+	name = filepath.Clean(name)
+	wd, err := unix.InotifyAddWatch(w.fd, name, inotifyMask)
+	if errors.Is(err, unix.ENOSPC) {
+		return fmt.Errorf("%s: %w", name, errWatchLimit)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paths[wd] = name
+	w.wds[name] = wd
+	return nil
+}
+
+func (w *inotifyWatcher) Remove(name string) error {
+	name = filepath.Clean(name)
+	w.mu.Lock()
+	wd, ok := w.wds[name]
+	if ok {
+		delete(w.wds, name)
+		delete(w.paths, wd)
+	}
+	w.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%s: %w", name, fsnotify.ErrNonExistentWatch)
+	}
+	if _, err := unix.InotifyRmWatch(w.fd, uint32(wd)); err != nil && !errors.Is(err, unix.EINVAL) {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+func (w *inotifyWatcher) Close() (err error) {
+	w.closed.Do(func() {
+		close(w.done)
+		err = w.file.Close()
+	})
+	return err
+}
+
+func (w *inotifyWatcher) readEvents() {
+	defer close(w.events)
+	defer close(w.errors)
+
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrClosed) {
+				return
+			}
+			if !w.sendError(err) {
+				return
+			}
+			continue
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			raw := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(raw.Len)]
+			offset += unix.SizeofInotifyEvent + int(raw.Len)
+
+			if raw.Mask&unix.IN_Q_OVERFLOW != 0 {
+				if !w.sendError(fsnotify.ErrEventOverflow) {
+					return
+				}
+				continue
+			}
+			name, ok := w.eventPath(int(raw.Wd), raw.Mask, string(bytes.TrimRight(nameBytes, "\x00")))
+			if !ok {
+				continue
+			}
+			op := inotifyOp(raw.Mask)
+			if op == 0 {
+				continue
+			}
+			select {
+			case w.events <- fsnotify.Event{Name: name, Op: op}:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// eventPath returns the path of an event on the watch wd, and forgets the
+// watches the kernel dropped.
+func (w *inotifyWatcher) eventPath(wd int, mask uint32, name string) (string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	dir, ok := w.paths[wd]
+	if !ok {
+		return "", false
+	}
+	if mask&unix.IN_IGNORED != 0 {
+		delete(w.paths, wd)
+		delete(w.wds, dir)
+		return "", false
+	}
+	if name == "" {
+		return dir, true
+	}
+	return filepath.Join(dir, name), true
+}
+
+func (w *inotifyWatcher) sendError(err error) bool {
+	select {
+	case w.errors <- err:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// inotifyOp translates an inotify mask to fsnotify ops.
+func inotifyOp(mask uint32) fsnotify.Op {
+	var op fsnotify.Op
+	if mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+		op |= fsnotify.Create
+	}
+	if mask&(unix.IN_DELETE|unix.IN_DELETE_SELF) != 0 {
+		op |= fsnotify.Remove
+	}
+	if mask&unix.IN_MODIFY != 0 {
+		op |= fsnotify.Write
+	}
+	if mask&(unix.IN_MOVED_FROM|unix.IN_MOVE_SELF) != 0 {
+		op |= fsnotify.Rename
+	}
+	if mask&unix.IN_ATTRIB != 0 {
+		op |= fsnotify.Chmod
+	}
+	return op
+}
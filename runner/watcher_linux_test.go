@@ -0,0 +1,77 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestInotifyOp(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		mask uint32
+		want fsnotify.Op
+	}{
+		{mask: unix.IN_CREATE, want: fsnotify.Create},
+		{mask: unix.IN_MOVED_TO, want: fsnotify.Create},
+		{mask: unix.IN_DELETE, want: fsnotify.Remove},
+		{mask: unix.IN_DELETE_SELF, want: fsnotify.Remove},
+		{mask: unix.IN_MODIFY, want: fsnotify.Write},
+		{mask: unix.IN_MOVED_FROM, want: fsnotify.Rename},
+		{mask: unix.IN_ATTRIB, want: fsnotify.Chmod},
+		{mask: unix.IN_CREATE | unix.IN_ISDIR, want: fsnotify.Create},
+		{mask: unix.IN_IGNORED, want: 0},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, inotifyOp(tt.mask), "mask %#x", tt.mask)
+	}
+}
+
+func TestInotifyWatcher(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	w, err := newInotifyWatcher()
+	require.NoError(t, err)
+	require.NoError(t, w.Add(dir))
+	require.Error(t, w.Add(filepath.Join(dir, "missing")))
+
+	next := func() fsnotify.Event {
+		t.Helper()
+		select {
+		case ev := <-w.Events():
+			return ev
+		case err := <-w.Errors():
+			t.Fatalf("unexpected error: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event")
+		}
+		return fsnotify.Event{}
+	}
+
+	name := filepath.Join(dir, "main.go")
+	require.NoError(t, os.WriteFile(name, []byte("package main"), 0o644))
+	assert.Equal(t, fsnotify.Event{Name: name, Op: fsnotify.Create}, next())
+	assert.Equal(t, fsnotify.Event{Name: name, Op: fsnotify.Write}, next())
+	require.NoError(t, os.Remove(name))
+	assert.Equal(t, fsnotify.Event{Name: name, Op: fsnotify.Remove}, next())
+
+	require.NoError(t, w.Remove(dir))
+	require.ErrorIs(t, w.Remove(dir), fsnotify.ErrNonExistentWatch)
+
+	require.NoError(t, w.Close())
+	require.NoError(t, w.Close())
+	select {
+	case _, ok := <-w.Events():
+		assert.False(t, ok, "events are closed with the watcher")
+	case <-time.After(5 * time.Second):
+		t.Fatal("events not closed")
+	}
+}
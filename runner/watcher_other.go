@@ -0,0 +1,9 @@
+//go:build !linux
+
+package runner
+
+import "github.com/gohugoio/hugo/watcher/filenotify"
+
+func newEventWatcher() (filenotify.FileWatcher, error) {
+	return filenotify.NewEventWatcher()
+}
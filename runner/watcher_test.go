@@ -1,6 +1,13 @@
 package runner
 
-import "testing"
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gohugoio/hugo/watcher/filenotify"
+)
 
 func TestNewWatcher_EventWatcherWhenPollDisabled(t *testing.T) {
 	t.Parallel()
@@ -48,3 +55,76 @@ func TestNewWatcher_PollIntervalHasMinimum500ms(t *testing.T) {
 		t.Fatal("newWatcher() returned nil watcher")
 	}
 }
+
+func TestNewWatcher_SplitWatcherWhenPollDirs(t *testing.T) {
+	t.Parallel()
+
+	cfg := defaultConfig()
+	cfg.Root = t.TempDir()
+	cfg.Build.PollDirs = []string{"shared"}
+	cfg.Build.normalizePollDirs(cfg.Root)
+
+	w, err := newWatcher(&cfg)
+	if err != nil {
+		t.Fatalf("newWatcher() error = %v", err)
+	}
+	defer w.Close()
+	if _, ok := w.(*splitWatcher); !ok {
+		t.Fatalf("newWatcher() = %T, want *splitWatcher", w)
+	}
+}
+
+func TestSplitWatcher(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	local := filepath.Join(root, "local")
+	shared := filepath.Join(root, "shared")
+	for _, dir := range []string{local, shared} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	native, err := newEventWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	poller := filenotify.NewPollingWatcher(pollInterval(0))
+	w := newSplitWatcher(native, poller, []string{shared})
+	if w.watcherFor(filepath.Join(shared, "sub")) != poller || w.watcherFor(local) != native {
+		t.Fatal("paths are not routed by poll_dirs")
+	}
+	for _, dir := range []string{local, shared} {
+		if err := w.Add(dir); err != nil {
+			t.Fatalf("Add(%s) error = %v", dir, err)
+		}
+	}
+
+	want := map[string]bool{
+		filepath.Join(local, "a.go"):  true,
+		filepath.Join(shared, "b.go"): true,
+	}
+	for name := range want {
+		if err := os.WriteFile(name, []byte("package x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	timeout := time.After(5 * time.Second)
+	for len(want) > 0 {
+		select {
+		case ev := <-w.Events():
+			delete(want, ev.Name)
+		case err := <-w.Errors():
+			t.Fatalf("unexpected error: %v", err)
+		case <-timeout:
+			t.Fatalf("no events for %v", want)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, ok := <-w.Events(); ok {
+		t.Fatal("events are not closed with the watcher")
+	}
+}
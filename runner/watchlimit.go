@@ -0,0 +1,93 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+)
+
+// watchReserve is how many watches are kept free for the dirs created later
+// and for other programs.
+const watchReserve = 512
+
+// isPolled reports whether path is polled rather than watched with native
+// events.
+func (e *Engine) isPolled(path string) bool {
+	if e.config.Build.Poll {
+		return true
+	}
+	if w, ok := e.watcher.(*splitWatcher); ok {
+		return w.polls(path)
+	}
+	return e.config.Build.isPolled(path)
+}
+
+// pollDir polls dir and the dirs inside it from now on, and reports whether
+// the watcher can poll.
+func (e *Engine) pollDir(dir string) bool {
+	w, ok := e.watcher.(*splitWatcher)
+	if ok {
+		w.poll(dir)
+	}
+	return ok
+}
+
+// planPolling returns the dirs to poll, along with the dirs inside them,
+// because watching all of dirs would exceed the system's watch limit.
+func (e *Engine) planPolling(dirs []string) []string {
+	if _, ok := e.watcher.(*splitWatcher); !ok {
+		return nil
+	}
+	var native []string
+	for _, dir := range dirs {
+		if !e.isPolled(dir) {
+			native = append(native, dir)
+		}
+	}
+	if len(native) == 0 {
+		return nil
+	}
+	limit, used, ok := inotifyWatches()
+	if !ok {
+		return nil
+	}
+	polled := pollSubtrees(native, limit-used-watchReserve)
+	if len(polled) == 0 {
+		return nil
+	}
+	rels := make([]string, 0, len(polled))
+	for _, dir := range polled {
+		rels = append(rels, e.config.rel(dir))
+	}
+	if len(rels) > 3 {
+		rels = append(rels[:3], fmt.Sprintf("%d more", len(polled)-3))
+	}
+	e.watcherLog("fs.inotify.max_user_watches is %d and %d watches are in use, too few for %d dirs: polling %s",
+		limit, used, len(native), strings.Join(rels, ", "))
+	e.watcherLog("%s", raiseWatchLimitHint(used+len(native)))
+	return polled
+}
+
+// pollSubtrees returns the roots of the subtrees formed by the dirs past the
+// first budget ones. As dirs are in walk order, everything under such a root
+// is past budget too.
+func pollSubtrees(dirs []string, budget int) []string {
+	budget = max(budget, 0)
+	if len(dirs) <= budget {
+		return nil
+	}
+	var roots []string
+	for _, dir := range dirs[budget:] {
+		if len(roots) > 0 && isSubPath(roots[len(roots)-1], dir) {
+			continue
+		}
+		roots = append(roots, dir)
+	}
+	return roots
+}
+
+// raiseWatchLimitHint tells how to raise the watch limit, so that need
+// watches fit with room to spare.
+func raiseWatchLimitHint(need int) string {
+	limit := max(524288, need*2)
+	return fmt.Sprintf("Raise the limit with `sudo sysctl fs.inotify.max_user_watches=%d`, and set it in /etc/sysctl.conf to keep it after a reboot", limit)
+}
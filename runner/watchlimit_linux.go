@@ -0,0 +1,78 @@
+package runner
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// inotifyWatches returns the inotify watch limit of the user, and how many
+// watches the user's processes hold.
+func inotifyWatches() (limit, used int, ok bool) {
+	b, err := os.ReadFile("/proc/sys/fs/inotify/max_user_watches")
+	if err != nil {
+		return 0, 0, false
+	}
+	limit, err = strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, 0, false
+	}
+	return limit, countInotifyWatches("/proc", os.Getuid()), true
+}
+
+// countInotifyWatches counts the watches of the inotify fds of the processes
+// of uid, as listed in their fdinfo. Processes which can't be read are
+// skipped.
+func countInotifyWatches(proc string, uid int) int {
+	entries, err := os.ReadDir(proc)
+	if err != nil {
+		return 0
+	}
+	n := 0
+	for _, entry := range entries {
+		pid := entry.Name()
+		if _, err := strconv.Atoi(pid); err != nil || !entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); !ok || int(st.Uid) != uid {
+			continue
+		}
+		fds, err := os.ReadDir(filepath.Join(proc, pid, "fd"))
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(proc, pid, "fd", fd.Name()))
+			if err != nil || link != "anon_inode:inotify" {
+				continue
+			}
+			n += countFdinfoWatches(filepath.Join(proc, pid, "fdinfo", fd.Name()))
+		}
+	}
+	return n
+}
+
+// countFdinfoWatches counts the "inotify wd:" lines of an fdinfo file, one
+// per watch.
+func countFdinfoWatches(name string) int {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	n := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "inotify wd:") {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,60 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountInotifyWatches(t *testing.T) {
+	t.Parallel()
+
+	proc := t.TempDir()
+	writeFiles(t, proc, map[string]string{
+		"100/fdinfo/3":  "pos:\t0\nflags:\t02004000\ninotify wd:2 ino:1 sdev:8 mask:fce ignored_mask:0\ninotify wd:1 ino:2 sdev:8 mask:fce ignored_mask:0\n",
+		"100/fdinfo/4":  "pos:\t0\n",
+		"100/fdinfo/5":  "inotify wd:1 ino:3 sdev:8 mask:fce ignored_mask:0\n",
+		"200/fdinfo/3":  "inotify wd:1 ino:4 sdev:8 mask:fce ignored_mask:0\n",
+		"self/fdinfo/3": "inotify wd:1 ino:5 sdev:8 mask:fce ignored_mask:0\n",
+	})
+	for _, fd := range []struct{ pid, fd, target string }{
+		{"100", "3", "anon_inode:inotify"},
+		{"100", "4", "/dev/null"},
+		{"100", "5", "anon_inode:inotify"},
+		{"200", "3", "anon_inode:inotify"},
+		{"self", "3", "anon_inode:inotify"},
+	} {
+		dir := filepath.Join(proc, fd.pid, "fd")
+		require.NoError(t, os.MkdirAll(dir, 0o755))
+		require.NoError(t, os.Symlink(fd.target, filepath.Join(dir, fd.fd)))
+	}
+	// 200 has no readable fds
+	require.NoError(t, os.Chmod(filepath.Join(proc, "200", "fd"), 0o000))
+	t.Cleanup(func() { _ = os.Chmod(filepath.Join(proc, "200", "fd"), 0o755) })
+
+	want := 3
+	if os.Getuid() == 0 {
+		// root reads every dir
+		want = 4
+	}
+	assert.Equal(t, want, countInotifyWatches(proc, os.Getuid()))
+	assert.Equal(t, 0, countInotifyWatches(proc, os.Getuid()+1))
+}
+
+func TestInotifyWatches(t *testing.T) {
+	t.Parallel()
+
+	w, err := newInotifyWatcher()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = w.Close() })
+	require.NoError(t, w.Add(t.TempDir()))
+	require.NoError(t, w.Add(t.TempDir()))
+
+	limit, used, ok := inotifyWatches()
+	require.True(t, ok)
+	assert.Positive(t, limit)
+	assert.GreaterOrEqual(t, used, 2)
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package runner
+
+// inotifyWatches reports no watch limit: it is only checked on Linux.
+func inotifyWatches() (limit, used int, ok bool) {
+	return 0, 0, false
+}
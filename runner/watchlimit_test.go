@@ -0,0 +1,66 @@
+package runner
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gohugoio/hugo/watcher/filenotify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPollSubtrees(t *testing.T) {
+	t.Parallel()
+
+	abs := func(paths ...string) []string {
+		for i, p := range paths {
+			paths[i] = filepath.FromSlash("/app/" + p)
+		}
+		return paths
+	}
+	// walk order
+	dirs := abs("api", "api/v1", "api/v2", "cmd", "web", "web/src", "web/src/ui", "web/static")
+
+	assert.Nil(t, pollSubtrees(dirs, 8))
+	assert.Nil(t, pollSubtrees(dirs, 100))
+	assert.Equal(t, abs("web"), pollSubtrees(dirs, 4))
+	assert.Equal(t, abs("web/src/ui", "web/static"), pollSubtrees(dirs, 6))
+	assert.Equal(t, abs("api/v2", "cmd", "web"), pollSubtrees(dirs, 2))
+	assert.Equal(t, abs("api", "cmd", "web"), pollSubtrees(dirs, -10))
+}
+
+// limitedWatcher is a native watcher which is out of watches.
+type limitedWatcher struct {
+	events chan fsnotify.Event
+	errors chan error
+}
+
+func (w *limitedWatcher) Events() <-chan fsnotify.Event { return w.events }
+func (w *limitedWatcher) Errors() <-chan error          { return w.errors }
+func (w *limitedWatcher) Add(name string) error         { return errWatchLimit }
+func (w *limitedWatcher) Remove(name string) error      { return nil }
+func (w *limitedWatcher) Close() error                  { return nil }
+
+func TestWatchPathFallsBackToPolling(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"api/api.go": "package api", "web/app.go": "package web"})
+	cfg := defaultConfig()
+	cfg.Root = root
+	cfg.Log.Silent = true
+	require.NoError(t, cfg.preprocess(nil))
+	poller := filenotify.NewPollingWatcher(pollInterval(0))
+	w := newSplitWatcher(&limitedWatcher{}, poller, nil)
+	t.Cleanup(func() { _ = w.Close() })
+	e := &Engine{config: &cfg, watcher: w, ignore: newIgnoreRules(root, false), watched: make(map[string]struct{})}
+
+	require.NoError(t, e.watching(root))
+	for _, dir := range []string{".", "api", "web"} {
+		path := filepath.Join(root, dir)
+		assert.True(t, e.isWatched(path), dir)
+		assert.True(t, e.isPolled(path), dir)
+	}
+	assert.True(t, e.watchLimitHit.Load())
+}
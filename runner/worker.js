@@ -1,5 +1,11 @@
 (() => {
+    const STATUS_TYPES = [
+        'build-started', 'build-failed', 'build-succeeded', 'app-starting', 'app-ready', 'runtime-error',
+    ];
+
     const ports = new Set();
+    // the last status seen, handed to windows connecting later
+    let lastStatus = null;
     let sse = null;
     let terminationTimer = null;
     let reconnectTimer = null;
@@ -16,6 +22,8 @@
         // Initialize the EventSource once
         if (!sse) {
             initSSE();
+        } else if (lastStatus) {
+            port.postMessage(lastStatus);
         }
 
         // Handle graceful disconnect message from port
@@ -39,12 +47,19 @@
 
         sse = new EventSource("/__air_internal/sse");
 
-        sse.addEventListener('reload', () => {
-            broadcast({ type: 'reload' });
+        sse.addEventListener('reload', (e) => {
+            broadcast({ type: 'reload', data: e.data });
+        });
+
+        sse.addEventListener('assets-changed', (e) => {
+            broadcast({ type: 'assets-changed', data: e.data });
         });
 
-        sse.addEventListener('build-failed', (e) => {
-            broadcast({ type: 'build-failed', data: e.data });
+        STATUS_TYPES.forEach((type) => {
+            sse.addEventListener(type, (e) => {
+                lastStatus = { type: type, data: e.data };
+                broadcast(lastStatus);
+            });
         });
 
         sse.onopen = () => {